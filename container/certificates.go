@@ -0,0 +1,176 @@
+package container
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Certificates holds a throwaway CA together with a server and a client certificate signed by it.
+// All certificates and keys are PEM encoded and also written to a temporary directory, so they
+// can be handed to drivers that only accept file paths (e.g. sslrootcert for libpq).
+type Certificates struct {
+	CACert     []byte
+	ServerCert []byte
+	ServerKey  []byte
+	ClientCert []byte
+	ClientKey  []byte
+	dir        string
+}
+
+// newCertificates generates a CA, a server certificate valid for all given hosts and a client
+// certificate with clientCommonName as subject common name.
+func newCertificates(clientCommonName string, hosts ...string) (*Certificates, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "testsetup CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	serverCert, serverKey, err := signCertificate(serverTemplate, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: clientCommonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientCert, clientKey, err := signCertificate(clientTemplate, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "testsetup-certs-")
+	if err != nil {
+		return nil, err
+	}
+	c := &Certificates{
+		CACert:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		ServerCert: serverCert,
+		ServerKey:  serverKey,
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+		dir:        dir,
+	}
+	files := map[string][]byte{
+		"ca.crt":     c.CACert,
+		"server.crt": c.ServerCert,
+		"server.key": c.ServerKey,
+		"client.crt": c.ClientCert,
+		"client.key": c.ClientKey,
+	}
+	for name, content := range files {
+		// Private keys are rejected by most clients if they are readable by others.
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func signCertificate(template *x509.Certificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (
+	certPEM []byte,
+	keyPEM []byte,
+	err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// CACertFile returns the path of the PEM encoded CA certificate.
+func (c *Certificates) CACertFile() string {
+	return filepath.Join(c.dir, "ca.crt")
+}
+
+// ClientCertFile returns the path of the PEM encoded client certificate.
+func (c *Certificates) ClientCertFile() string {
+	return filepath.Join(c.dir, "client.crt")
+}
+
+// ClientKeyFile returns the path of the PEM encoded client key.
+func (c *Certificates) ClientKeyFile() string {
+	return filepath.Join(c.dir, "client.key")
+}
+
+// ClientTLSConfig returns a tls.Config that trusts the CA and presents the client certificate.
+// serverName has to be one of the hosts the server certificate was issued for.
+func (c *Certificates) ClientTLSConfig(serverName string) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c.CACert) {
+		return nil, errors.New("unable to parse CA certificate")
+	}
+	clientCert, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// remove deletes the certificate files from disk.
+func (c *Certificates) remove() error {
+	return os.RemoveAll(c.dir)
+}
@@ -0,0 +1,51 @@
+package container
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCertificates(t *testing.T) {
+	certs, err := newCertificates("test", "postgres-container", "localhost", "127.0.0.1")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, certs.remove()) }()
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(certs.CACert))
+
+	block, _ := pem.Decode(certs.ServerCert)
+	require.NotNil(t, block)
+	serverCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	for _, host := range []string{"postgres-container", "localhost", "127.0.0.1"} {
+		_, err = serverCert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+
+	block, _ = pem.Decode(certs.ClientCert)
+	require.NotNil(t, block)
+	clientCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, "test", clientCert.Subject.CommonName)
+	_, err = clientCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+
+	info, err := os.Stat(certs.ClientKeyFile())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	config, err := certs.ClientTLSConfig("localhost")
+	require.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	_, err = tls.X509KeyPair(certs.ServerCert, certs.ServerKey)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,226 @@
+package container
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// debeziumSlotName is the replication slot the connector streams the changes from.
+const debeziumSlotName = "debezium"
+
+type debeziumPostgres struct {
+	db      *postgres
+	kafka   *kafka
+	connect *kafkaConnect
+	opts    DebeziumPostgresOpts
+}
+
+// DebeziumPostgresContainer is a Container running postgres, kafka and a debezium connector streaming
+// the changes of the captured tables into kafka.
+type DebeziumPostgresContainer interface {
+	testsetup.Container
+	Postgres() PostgresContainer
+	Kafka() KafkaContainer
+	Connect() KafkaConnectContainer
+	// Topic returns the topic the changes of a table like "public.orders" are written to.
+	Topic(table string) string
+}
+
+type DebeziumPostgresOpts struct {
+	// ContainerName is the prefix of all containers: <name>-db, <name>-kafka and <name>-connect.
+	ContainerName string
+	NetworkID     string
+	// ExternalHost is the hostname the containers are reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost        string
+	DBName              string
+	DBUser              string
+	DBPass              string
+	DBExternalPort      string
+	KafkaExternalPort   string
+	ConnectExternalPort string
+	// Setup holds SQL statements run before the connector is registered, e.g. to create the captured tables.
+	Setup []string
+	// Tables to capture, schema qualified like "public.orders".
+	Tables []string
+	// TopicPrefix is the first part of the topic names. Defaults to "cdc".
+	TopicPrefix string
+	// ConnectorConfig overrides the connector configuration. Events are plain JSON without schemas by default.
+	ConnectorConfig map[string]string
+}
+
+// WithDebeziumPostgres returns a Container in order to spawn postgres with logical decoding, kafka in KRaft mode
+// and a debezium connect worker. The start returns once the connector finished the initial snapshot of the tables
+// and streams the changes.
+func WithDebeziumPostgres(opts DebeziumPostgresOpts) DebeziumPostgresContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	if opts.TopicPrefix == "" {
+		opts.TopicPrefix = "cdc"
+	}
+	d := &debeziumPostgres{opts: opts}
+	d.db = newPostgres(PostgresContainerOpts{
+		ContainerName:   opts.ContainerName + "-db",
+		NetworkID:       opts.NetworkID,
+		DBName:          opts.DBName,
+		DBUser:          opts.DBUser,
+		DBPass:          opts.DBPass,
+		ExternalDBHost:  opts.ExternalHost,
+		DBExternalPort:  opts.DBExternalPort,
+		DBInternalPort:  "5432",
+		LogicalDecoding: true,
+	})
+	d.kafka = WithKafka(KafkaOpts{
+		ContainerName:     opts.ContainerName + "-kafka",
+		ContainerNamePort: "9091",
+		ExternalHostName:  opts.ExternalHost,
+		ExternalPort:      opts.KafkaExternalPort,
+		NetworkID:         opts.NetworkID,
+		KRaft:             true,
+	}).(*kafka)
+	d.connect = WithKafkaConnect(KafkaConnectOpts{
+		ContainerName:          opts.ContainerName + "-connect",
+		NetworkID:              opts.NetworkID,
+		KafkaContainerNamePort: "9091",
+		ExternalHost:           opts.ExternalHost,
+		ExternalPort:           opts.ConnectExternalPort,
+		Image:                  KafkaConnectImageDebezium,
+	}, d.kafka).(*kafkaConnect)
+	return d
+}
+
+// connector returns the debezium postgres connector capturing the tables.
+func (d *debeziumPostgres) connector() KafkaConnector {
+	config := map[string]string{
+		"connector.class":                "io.debezium.connector.postgresql.PostgresConnector",
+		"tasks.max":                      "1",
+		"database.hostname":              d.db.GetHostname(),
+		"database.port":                  "5432",
+		"database.user":                  d.opts.DBUser,
+		"database.password":              d.opts.DBPass,
+		"database.dbname":                d.opts.DBName,
+		"topic.prefix":                   d.opts.TopicPrefix,
+		"plugin.name":                    "pgoutput",
+		"slot.name":                      debeziumSlotName,
+		"publication.autocreate.mode":    "filtered",
+		"table.include.list":             strings.Join(d.opts.Tables, ","),
+		"key.converter.schemas.enable":   "false",
+		"value.converter.schemas.enable": "false",
+	}
+	for key, value := range d.opts.ConnectorConfig {
+		config[key] = value
+	}
+	return KafkaConnector{Name: "postgres", Config: config}
+}
+
+func (d *debeziumPostgres) Postgres() PostgresContainer {
+	return d.db
+}
+
+func (d *debeziumPostgres) Kafka() KafkaContainer {
+	return d.kafka
+}
+
+func (d *debeziumPostgres) Connect() KafkaConnectContainer {
+	return d.connect
+}
+
+func (d *debeziumPostgres) Topic(table string) string {
+	return d.opts.TopicPrefix + "." + table
+}
+
+func (d *debeziumPostgres) GetHostname() string {
+	return d.db.GetHostname()
+}
+
+func (d *debeziumPostgres) GetPorts() []int {
+	ports := append(d.db.GetPorts(), d.kafka.GetPorts()...)
+	return append(ports, d.connect.GetPorts()...)
+}
+
+func (d *debeziumPostgres) Size() int {
+	return 3
+}
+
+func (d *debeziumPostgres) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if err := d.db.Start(auth, pool); err != nil {
+		return err
+	}
+	if err := d.setup(); err != nil {
+		return err
+	}
+	if err := d.kafka.Start(auth, pool); err != nil {
+		return err
+	}
+	if err := d.connect.Start(auth, pool); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := d.connect.CreateConnector(ctx, d.connector()); err != nil {
+		return err
+	}
+	return pool.Retry(func() error {
+		return d.streaming(ctx)
+	})
+}
+
+// setup runs the setup statements.
+func (d *debeziumPostgres) setup() error {
+	db, err := sql.Open("postgres", d.db.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, statement := range d.opts.Setup {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("setup statement failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// streaming checks that the replication slot is in use, which happens after the snapshot is done.
+func (d *debeziumPostgres) streaming(ctx context.Context) error {
+	db, err := sql.Open("postgres", d.db.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var active bool
+	if err := db.QueryRowContext(ctx, "SELECT active FROM pg_replication_slots WHERE slot_name = $1",
+		debeziumSlotName).Scan(&active); err != nil {
+		return err
+	}
+	if !active {
+		return fmt.Errorf("replication slot %s is not streaming yet", debeziumSlotName)
+	}
+	return nil
+}
+
+func (d *debeziumPostgres) Stop() error {
+	var errs []error
+	if d.connect.r != nil {
+		errs = append(errs, d.connect.Stop())
+	}
+	if d.kafka.r != nil {
+		errs = append(errs, d.kafka.Stop())
+	}
+	if d.db.r != nil {
+		errs = append(errs, d.db.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+func (d *debeziumPostgres) SetLabel(label map[string]string) {
+	d.db.SetLabel(label)
+	d.kafka.SetLabel(label)
+	d.connect.SetLabel(label)
+}
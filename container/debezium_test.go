@@ -0,0 +1,26 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebeziumPostgresConnector(t *testing.T) {
+	d := WithDebeziumPostgres(DebeziumPostgresOpts{
+		ContainerName:   "cdc",
+		DBName:          "orders",
+		DBUser:          "user",
+		DBPass:          "pass",
+		Tables:          []string{"public.orders", "public.items"},
+		ConnectorConfig: map[string]string{"snapshot.mode": "never"},
+	}).(*debeziumPostgres)
+	connector := d.connector()
+	assert.Equal(t, "io.debezium.connector.postgresql.PostgresConnector", connector.Config["connector.class"])
+	assert.Equal(t, "public.orders,public.items", connector.Config["table.include.list"])
+	assert.Equal(t, "orders", connector.Config["database.dbname"])
+	assert.Equal(t, "never", connector.Config["snapshot.mode"])
+	assert.Equal(t, "cdc.public.orders", d.Topic("public.orders"))
+	assert.True(t, d.db.opts.LogicalDecoding)
+	assert.Equal(t, KafkaConnectImageDebezium.Repository, d.connect.Opts.Repository)
+}
@@ -1,23 +1,77 @@
 package container
 
 import (
+	"context"
+	"encoding/base64"
 	"math/rand"
-
 	"strconv"
+	"testing"
+	"time"
 
 	"github.com/4ND3R50N/testsetup"
+	"github.com/google/uuid"
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
 	kafkaClient "github.com/segmentio/kafka-go"
 )
 
+// kafkaControllerPort is the port of the KRaft controller listener, outside the range of the internal port.
+const kafkaControllerPort = "29500"
+
 type kafka struct {
-	topics        []string
-	hostName      string
-	port          string
-	kafkaInitPort string
-	Opts          testsetup.DockerContainerOpts
-	r             *dockertest.Resource
+	topics       []TopicSpec
+	hostName     string
+	port         string
+	internalPort string
+	Opts         testsetup.DockerContainerOpts
+	r            *dockertest.Resource
+	pool         *dockertest.Pool
+	// replicationFactor is used for the topics and the internal topics.
+	replicationFactor int
+	// externalAddress is the address to reach the broker from the outside.
+	externalAddress string
+	kafkaAccess
+}
+
+// KafkaContainer is a Container running kafka that provides connection details.
+type KafkaContainer interface {
+	testsetup.Container
+	// Brokers returns the external address of every broker.
+	Brokers() []string
+	// Certificates returns the generated TLS material or nil if TLS is disabled.
+	Certificates() *Certificates
+	// Dialer returns a kafka-go dialer for the external listener authenticated as user.
+	// The user is ignored if SASL is disabled.
+	Dialer(user string) (*kafkaClient.Dialer, error)
+	// Transport returns a kafka-go transport, e.g. for kafka.Writer or kafka.Client, authenticated as user.
+	Transport(user string) (*kafkaClient.Transport, error)
+	// ClientConfig returns the librdkafka properties to connect as user, e.g. for confluent-kafka-go.
+	ClientConfig(user string) (map[string]string, error)
+	// GrantACL creates the ACLs and waits until all brokers enforce them. Requires ACLs in KafkaSecurityOpts.
+	GrantACL(ctx context.Context, acls ...KafkaACL) error
+	// RevokeACL deletes the ACLs and waits until no broker enforces them anymore.
+	RevokeACL(ctx context.Context, acls ...KafkaACL) error
+	// ExpectMessages reads the topic from the beginning until a message for every matcher arrived,
+	// otherwise it fails the test after 10 seconds listing the seen messages. The matched messages
+	// are returned in the order of the matchers.
+	ExpectMessages(t testing.TB, topic string, matchers ...MessageMatcher) []ReceivedMessage
+	// ExpectMessagesWith is ExpectMessages with control over the start offset, timeout, ordering and decoding.
+	ExpectMessagesWith(t testing.TB, topic string, opts ExpectOpts, matchers ...MessageMatcher) []ReceivedMessage
+	// RecordTopics records the messages produced to the topics during the test, see TopicRecorder.
+	RecordTopics(t testing.TB, opts TopicRecorderOpts) *TopicRecorder
+	// CaptureFixture writes the records of the topic to a fixture file for TopicSpec.Fixtures, see CaptureKafkaFixture.
+	CaptureFixture(ctx context.Context, topic string, file string) (int, error)
+	// DescribeConsumerGroups returns members, committed offsets and lag of the groups, or of all groups if none are given.
+	DescribeConsumerGroups(ctx context.Context, groups ...string) ([]ConsumerGroup, error)
+	// WaitForGroupCaughtUp waits until the group committed the end offset of every partition of the topics,
+	// or of the partitions it committed offsets for if no topics are given.
+	WaitForGroupCaughtUp(ctx context.Context, group string, topics ...string) error
+	// ResetGroupOffsets sets the committed offset of the group for every partition of the topic.
+	// The offset can be kafka.FirstOffset or kafka.LastOffset. The group must not have active members.
+	ResetGroupOffsets(ctx context.Context, group string, topic string, offset int64) error
+	// DeleteGroupOffsets deletes the committed offsets of the group for the topics or the whole group if no topics
+	// are given. The group must not have active members.
+	DeleteGroupOffsets(ctx context.Context, group string, topics ...string) error
 }
 
 // KafkaOpts configures the kafka container
@@ -33,49 +87,126 @@ type KafkaOpts struct {
 	// ExternalPort accepts connections in combination with ExternalHostName.
 	ExternalPort      string
 	ZookeeperHostName string
-	ZookeeperPort     string
-	NetworkID         string
+	// ZookeeperPort is the client port of zookeeper within the network, 2181 for WithZookeeper.
+	ZookeeperPort string
+	// ZookeeperConnect overrides ZookeeperHostName and ZookeeperPort, e.g. with the ConnectString of
+	// WithZookeeperEnsemble.
+	ZookeeperConnect string
+	NetworkID        string
+
+	// KRaft runs kafka without zookeeper as combined broker and controller.
+	// ZookeeperHostName and ZookeeperPort are ignored.
+	KRaft bool
+	// ClusterID identifies the KRaft cluster. A random ID is generated if empty.
+	ClusterID string
+
+	// Topics are created on start in addition to the bare topic names, which use the defaults of TopicSpec.
+	Topics []TopicSpec
+
+	// Security configures SASL and TLS on the external listener. If nil, it accepts plaintext connections.
+	Security *KafkaSecurityOpts
+
+	// Redpanda starts redpanda in dev-container mode instead of kafka, see WithRedpanda. The zookeeper
+	// and KRaft settings are ignored, the start fails if Security is set.
+	Redpanda bool
 }
 
 // WithKafka returns a Container in order to spawn a kafka container
 // it can be deployed with zookeeper (recommended) to use monitoring tools
-func WithKafka(opts KafkaOpts, topics ...string) testsetup.Container {
+func WithKafka(opts KafkaOpts, topics ...string) KafkaContainer {
 	opts.ExternalHostName = validateHost(opts.ExternalHostName)
-	kafkaInitConnectPort := strconv.Itoa(29000 + rand.Intn(100))
+	if opts.Redpanda {
+		return withRedpandaFromKafka(opts, topics...)
+	}
+	if opts.KRaft && opts.ClusterID == "" {
+		opts.ClusterID = newKafkaClusterID()
+	}
+	k := newKafka(opts, kafkaNode{
+		id:                1,
+		quorumVoters:      "1@" + opts.ContainerName + ":" + kafkaControllerPort,
+		replicationFactor: 1,
+	}, topicSpecs(1, opts.Topics, topics...), newKafkaSecurity(opts.Security, opts.ExternalHostName, opts.ContainerName))
+	k.Opts.HealthCheck = func(pool *dockertest.Pool, resource *dockertest.Resource) error {
+		if err := k.security.createScramUsers(context.Background(), pool, resource, k.internalPort); err != nil {
+			return err
+		}
+		dialer, err := k.Dialer(k.security.adminUser())
+		if err != nil {
+			return err
+		}
+		if err := kafkaHealthCheck(pool, dialer, k.externalAddress); err != nil {
+			return err
+		}
+		return nil
+	}
+	return k
+}
+
+// kafkaNode holds the settings that differ between the brokers of a cluster.
+type kafkaNode struct {
+	id                int
+	quorumVoters      string
+	replicationFactor int
+}
+
+func newKafka(opts KafkaOpts, node kafkaNode, topics []TopicSpec, security *kafkaSecurity) *kafka {
+	kafkaInternalPort := strconv.Itoa(29000 + rand.Intn(100))
+	minISR := 1
+	if node.replicationFactor > 2 {
+		minISR = node.replicationFactor - 1
+	}
 	env := map[string]string{
-		"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP":           "PLAINTEXT:PLAINTEXT,PLAINTEXT_DOCKER:PLAINTEXT,INTERNAL:PLAINTEXT",
-		"KAFKA_LISTENERS":                                "PLAINTEXT://:9092,PLAINTEXT_DOCKER://:" + opts.ContainerNamePort + ",INTERNAL://:" + kafkaInitConnectPort,
-		"KAFKA_ADVERTISED_LISTENERS":                     "PLAINTEXT://" + opts.ExternalHostName + ":" + opts.ExternalPort + ",PLAINTEXT_DOCKER://" + opts.ContainerName + ":" + opts.ContainerNamePort + ",INTERNAL://" + opts.ContainerName + ":" + kafkaInitConnectPort,
+		"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP":           "EXTERNAL:" + security.protocol() + ",PLAINTEXT_DOCKER:PLAINTEXT,INTERNAL:PLAINTEXT",
+		"KAFKA_LISTENERS":                                "EXTERNAL://:9092,PLAINTEXT_DOCKER://:" + opts.ContainerNamePort + ",INTERNAL://:" + kafkaInternalPort,
+		"KAFKA_ADVERTISED_LISTENERS":                     "EXTERNAL://" + opts.ExternalHostName + ":" + opts.ExternalPort + ",PLAINTEXT_DOCKER://" + opts.ContainerName + ":" + opts.ContainerNamePort + ",INTERNAL://" + opts.ContainerName + ":" + kafkaInternalPort,
 		"KAFKA_INTER_BROKER_LISTENER_NAME":               "INTERNAL",
-		"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR":         "1",
-		"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR":            "1",
-		"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR": "1",
-	}
-	if opts.ZookeeperHostName != "" {
-		env["KAFKA_ZOOKEEPER_CONNECT"] = opts.ZookeeperHostName + ":" + opts.ZookeeperPort
-	}
-	kafkaContainer := kafka{
-		hostName:      opts.ContainerName,
-		topics:        topics,
-		port:          opts.ExternalPort,
-		kafkaInitPort: kafkaInitConnectPort,
+		"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR":         strconv.Itoa(node.replicationFactor),
+		"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR":            strconv.Itoa(minISR),
+		"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR": strconv.Itoa(node.replicationFactor),
+		"KAFKA_DEFAULT_REPLICATION_FACTOR":               strconv.Itoa(node.replicationFactor),
+		"KAFKA_MIN_INSYNC_REPLICAS":                      strconv.Itoa(minISR),
+	}
+	for key, value := range security.brokerEnv(opts.KRaft) {
+		env[key] = value
+	}
+	tag := "7.2.1"
+	if opts.KRaft {
+		// The image supports KRaft without zookeeper since 7.4.
+		tag = "7.6.1"
+		env["CLUSTER_ID"] = opts.ClusterID
+		env["KAFKA_NODE_ID"] = strconv.Itoa(node.id)
+		env["KAFKA_PROCESS_ROLES"] = "broker,controller"
+		env["KAFKA_CONTROLLER_LISTENER_NAMES"] = "CONTROLLER"
+		env["KAFKA_CONTROLLER_QUORUM_VOTERS"] = node.quorumVoters
+		env["KAFKA_LISTENER_SECURITY_PROTOCOL_MAP"] += ",CONTROLLER:PLAINTEXT"
+		env["KAFKA_LISTENERS"] += ",CONTROLLER://:" + kafkaControllerPort
+	} else if opts.ZookeeperConnect != "" || opts.ZookeeperHostName != "" {
+		env["KAFKA_BROKER_ID"] = strconv.Itoa(node.id)
+		env["KAFKA_ZOOKEEPER_CONNECT"] = opts.ZookeeperConnect
+		if opts.ZookeeperConnect == "" {
+			env["KAFKA_ZOOKEEPER_CONNECT"] = opts.ZookeeperHostName + ":" + opts.ZookeeperPort
+		}
+	}
+	k := &kafka{
+		hostName:          opts.ContainerName,
+		externalAddress:   opts.ExternalHostName + ":" + opts.ExternalPort,
+		topics:            topics,
+		port:              opts.ExternalPort,
+		internalPort:      kafkaInternalPort,
+		replicationFactor: node.replicationFactor,
 		Opts: testsetup.DockerContainerOpts{
 			Repository:    "confluentinc/cp-kafka",
 			ContainerName: opts.ContainerName,
-			Tag:           "7.2.1",
+			Tag:           tag,
 			PortBinding:   map[string]string{opts.ExternalPort: "9092"},
 			Env:           env,
 			ExpireTime:    5,
-			HealthCheck: func(pool *dockertest.Pool, _ *dockertest.Resource) error {
-				if err := kafkaHealthCheck(pool, opts.ExternalHostName, opts.ExternalPort); err != nil {
-					return err
-				}
-				return nil
-			},
-			NetworkID: opts.NetworkID,
+			HealthCheck:   noHealthCheck,
+			NetworkID:     opts.NetworkID,
 		},
 	}
-	return &kafkaContainer
+	k.kafkaAccess = kafkaAccess{security: security, addresses: k.Brokers, admin: k.adminClient}
+	return k
 }
 
 func (k *kafka) GetHostname() string {
@@ -88,6 +219,15 @@ func (k *kafka) GetPorts() []int {
 }
 
 func (k *kafka) Start(_ docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if err := k.security.validate(); err != nil {
+		return err
+	}
+	if err := k.security.enableTLS(); err != nil {
+		return err
+	}
+	if k.security.certs != nil {
+		k.Opts.EntryPoint = []string{"/bin/sh", "-c", k.security.setup(k.Opts.Env) + " && exec /etc/confluent/docker/run"}
+	}
 	auth := docker.AuthConfiguration{}
 	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, k.Opts)
 	if err != nil {
@@ -95,65 +235,74 @@ func (k *kafka) Start(_ docker.AuthConfiguration, pool *dockertest.Pool) error {
 	}
 	k.hostName = *hostname
 	k.r = resource
+	k.pool = pool
 	if len(k.topics) > 0 {
-		err := initKafka(auth, pool, *k)
+		client, err := k.adminClient()
 		if err != nil {
+			_ = resource.Close()
+			return err
+		}
+		if err := createTopics(context.Background(), pool, client, k.topics); err != nil {
+			_ = resource.Close()
 			return err
 		}
 	}
 	return nil
 }
 
-func initKafka(auth docker.AuthConfiguration, pool *dockertest.Pool, k kafka) error {
-	command := "kafka-topics --bootstrap-server " + k.hostName + ":" + k.kafkaInitPort + " --list"
-	for _, topic := range k.topics {
-		command += " && "
-		command += "kafka-topics --bootstrap-server " +
-			k.hostName +
-			":" + k.kafkaInitPort + " --create --if-not-exists --topic " + topic + " --replication-factor 1 --partitions 1"
+// adminClient returns a client to talk to the broker over the kafka protocol from the outside.
+func (k *kafka) adminClient() (*kafkaClient.Client, error) {
+	transport, err := k.security.adminTransport()
+	if err != nil {
+		return nil, err
 	}
-	kafkaInit := kafka{
-		Opts: testsetup.DockerContainerOpts{
-			Repository: "confluentinc/cp-kafka",
-			Tag:        "7.2.1",
-			ExpireTime: 5,
-			EntryPoint: []string{"/bin/sh", "-c"},
-			Commands:   []string{command},
-			HealthCheck: func(pool *dockertest.Pool, resource *dockertest.Resource) error {
-				_, err := pool.Client.WaitContainer(resource.Container.ID)
-				if err != nil {
-					return err
-				}
-				return nil
-			},
-			NetworkID: k.Opts.NetworkID,
-			Labels: map[string]string{
-				"testsetup": "kafka-init",
-			},
-		},
+	return &kafkaClient.Client{Addr: kafkaClient.TCP(k.externalAddress), Timeout: 10 * time.Second, Transport: transport}, nil
+}
+
+func (k *kafka) Brokers() []string {
+	return []string{k.externalAddress}
+}
+
+func (k *kafka) GrantACL(ctx context.Context, acls ...KafkaACL) error {
+	client, err := k.adminClient()
+	if err != nil {
+		return err
 	}
-	_, _, err := testsetup.RunDockerContainer(auth, pool, kafkaInit.Opts)
+	return grantACLs(ctx, k.pool, client, k.Brokers(), acls)
+}
+
+func (k *kafka) RevokeACL(ctx context.Context, acls ...KafkaACL) error {
+	client, err := k.adminClient()
 	if err != nil {
 		return err
 	}
-	return nil
+	return revokeACLs(ctx, k.pool, client, k.Brokers(), acls)
 }
 
 func (k *kafka) Stop() error {
-	return k.r.Close()
+	k.recorders.finish()
+	k.security.closeAdminTransport()
+	if err := k.r.Close(); err != nil {
+		return err
+	}
+	if k.security.certs != nil {
+		return k.security.certs.remove()
+	}
+	return nil
 }
 
 func (k *kafka) SetLabel(label map[string]string) {
 	k.Opts.Labels = label
 }
 
-func kafkaHealthCheck(pool *dockertest.Pool, host string, port string) error {
+func kafkaHealthCheck(pool *dockertest.Pool, dialer *kafkaClient.Dialer, address string) error {
 	if err := pool.Retry(func() error {
-		conn, err := kafkaClient.Dial("tcp", host+":"+port)
+		conn, err := dialer.Dial("tcp", address)
 		if err != nil {
 			return err
 		}
 		_, err = conn.Brokers()
+		_ = conn.Close()
 		if err != nil {
 			return err
 		}
@@ -163,3 +312,9 @@ func kafkaHealthCheck(pool *dockertest.Pool, host string, port string) error {
 	}
 	return nil
 }
+
+// newKafkaClusterID returns a random KRaft cluster ID, a base64 encoded UUID like kafka-storage random-uuid creates.
+func newKafkaClusterID() string {
+	id := uuid.New()
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
@@ -0,0 +1,91 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+// kafkaAccess implements the client side of KafkaContainer, which is the same for kafka, kafka clusters and
+// redpanda. The containers embed it and provide their broker addresses and admin client.
+type kafkaAccess struct {
+	security  *kafkaSecurity
+	recorders topicRecorders
+	// addresses returns the external address of every broker.
+	addresses func() []string
+	// admin returns a client for admin requests over the kafka protocol.
+	admin func() (*kafkaClient.Client, error)
+}
+
+func (a *kafkaAccess) Certificates() *Certificates {
+	return a.security.certs
+}
+
+func (a *kafkaAccess) Dialer(user string) (*kafkaClient.Dialer, error) {
+	return a.security.dialer(user)
+}
+
+func (a *kafkaAccess) Transport(user string) (*kafkaClient.Transport, error) {
+	return a.security.transport(user)
+}
+
+func (a *kafkaAccess) ClientConfig(user string) (map[string]string, error) {
+	return a.security.clientConfig(a.addresses(), user)
+}
+
+func (a *kafkaAccess) ExpectMessages(t testing.TB, topic string, matchers ...MessageMatcher) []ReceivedMessage {
+	t.Helper()
+	return a.ExpectMessagesWith(t, topic, ExpectOpts{}, matchers...)
+}
+
+func (a *kafkaAccess) ExpectMessagesWith(t testing.TB, topic string, opts ExpectOpts,
+	matchers ...MessageMatcher) []ReceivedMessage {
+	t.Helper()
+	return expectMessagesAs(t, a.security, a.addresses(), topic, opts, matchers)
+}
+
+func (a *kafkaAccess) RecordTopics(t testing.TB, opts TopicRecorderOpts) *TopicRecorder {
+	t.Helper()
+	return recordTopicsAs(t, a.security, a.addresses(), opts, &a.recorders)
+}
+
+func (a *kafkaAccess) CaptureFixture(ctx context.Context, topic string, file string) (int, error) {
+	dialer, err := a.security.dialer(a.security.adminUser())
+	if err != nil {
+		return 0, err
+	}
+	return CaptureKafkaFixture(ctx, dialer, a.addresses(), topic, file)
+}
+
+func (a *kafkaAccess) DescribeConsumerGroups(ctx context.Context, groups ...string) ([]ConsumerGroup, error) {
+	client, err := a.admin()
+	if err != nil {
+		return nil, err
+	}
+	return describeGroups(ctx, client, groups)
+}
+
+func (a *kafkaAccess) WaitForGroupCaughtUp(ctx context.Context, group string, topics ...string) error {
+	client, err := a.admin()
+	if err != nil {
+		return err
+	}
+	return waitForGroupCaughtUp(ctx, client, group, topics)
+}
+
+func (a *kafkaAccess) ResetGroupOffsets(ctx context.Context, group string, topic string, offset int64) error {
+	client, err := a.admin()
+	if err != nil {
+		return err
+	}
+	return resetGroupOffsets(ctx, client, group, topic, offset)
+}
+
+func (a *kafkaAccess) DeleteGroupOffsets(ctx context.Context, group string, topics ...string) error {
+	client, err := a.admin()
+	if err != nil {
+		return err
+	}
+	return deleteGroupOffsets(ctx, client, group, topics)
+}
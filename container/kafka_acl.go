@@ -0,0 +1,151 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ory/dockertest"
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+// KafkaACL allows a user operations on topics or consumer groups.
+type KafkaACL struct {
+	User string
+	// ResourceType is kafka.ResourceTypeTopic or kafka.ResourceTypeGroup.
+	ResourceType kafkaClient.ResourceType
+	// Name of the topic or consumer group, "*" matches all of them.
+	Name string
+	// Prefixed matches all resources whose name starts with Name.
+	Prefixed bool
+	// Operations allowed, e.g. kafka.ACLOperationTypeRead.
+	Operations []kafkaClient.ACLOperationType
+}
+
+// TopicACL returns an ACL allowing the user the operations on the topic.
+func TopicACL(user string, topic string, operations ...kafkaClient.ACLOperationType) KafkaACL {
+	return KafkaACL{User: user, ResourceType: kafkaClient.ResourceTypeTopic, Name: topic, Operations: operations}
+}
+
+// GroupACL returns an ACL allowing the user the operations on the consumer group.
+func GroupACL(user string, group string, operations ...kafkaClient.ACLOperationType) KafkaACL {
+	return KafkaACL{User: user, ResourceType: kafkaClient.ResourceTypeGroup, Name: group, Operations: operations}
+}
+
+func (a KafkaACL) entries() []kafkaClient.ACLEntry {
+	patternType := kafkaClient.PatternTypeLiteral
+	if a.Prefixed {
+		patternType = kafkaClient.PatternTypePrefixed
+	}
+	entries := make([]kafkaClient.ACLEntry, 0, len(a.Operations))
+	for _, operation := range a.Operations {
+		entries = append(entries, kafkaClient.ACLEntry{
+			ResourceType:        a.ResourceType,
+			ResourceName:        a.Name,
+			ResourcePatternType: patternType,
+			Principal:           "User:" + a.User,
+			Host:                "*",
+			Operation:           operation,
+			PermissionType:      kafkaClient.ACLPermissionTypeAllow,
+		})
+	}
+	return entries
+}
+
+func aclEntries(acls []KafkaACL) []kafkaClient.ACLEntry {
+	var entries []kafkaClient.ACLEntry
+	for _, acl := range acls {
+		entries = append(entries, acl.entries()...)
+	}
+	return entries
+}
+
+// grantACLs creates the ACLs and waits until every broker enforces them.
+func grantACLs(ctx context.Context, pool *dockertest.Pool, client *kafkaClient.Client, brokers []string,
+	acls []KafkaACL) error {
+	entries := aclEntries(acls)
+	response, err := client.CreateACLs(ctx, &kafkaClient.CreateACLsRequest{ACLs: entries})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, err := range response.Errors {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to grant %s on %s to %s: %w",
+				entries[i].Operation, entries[i].ResourceName, entries[i].Principal, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return pool.Retry(func() error {
+		return aclsPropagated(ctx, client, brokers, entries, true)
+	})
+}
+
+// revokeACLs deletes the ACLs and waits until no broker enforces them anymore.
+func revokeACLs(ctx context.Context, pool *dockertest.Pool, client *kafkaClient.Client, brokers []string,
+	acls []KafkaACL) error {
+	entries := aclEntries(acls)
+	filters := make([]kafkaClient.DeleteACLsFilter, 0, len(entries))
+	for _, entry := range entries {
+		filters = append(filters, kafkaClient.DeleteACLsFilter{
+			ResourceTypeFilter:        entry.ResourceType,
+			ResourceNameFilter:        entry.ResourceName,
+			ResourcePatternTypeFilter: entry.ResourcePatternType,
+			PrincipalFilter:           entry.Principal,
+			HostFilter:                entry.Host,
+			Operation:                 entry.Operation,
+			PermissionType:            entry.PermissionType,
+		})
+	}
+	response, err := client.DeleteACLs(ctx, &kafkaClient.DeleteACLsRequest{Filters: filters})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, result := range response.Results {
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("unable to revoke %s on %s from %s: %w",
+				entries[i].Operation, entries[i].ResourceName, entries[i].Principal, result.Error))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return pool.Retry(func() error {
+		return aclsPropagated(ctx, client, brokers, entries, false)
+	})
+}
+
+// aclsPropagated checks that every broker knows the ACLs, or none of them if present is false.
+func aclsPropagated(ctx context.Context, client *kafkaClient.Client, brokers []string, entries []kafkaClient.ACLEntry,
+	present bool) error {
+	for _, broker := range brokers {
+		for _, entry := range entries {
+			response, err := client.DescribeACLs(ctx, &kafkaClient.DescribeACLsRequest{
+				Addr: kafkaClient.TCP(broker),
+				Filter: kafkaClient.ACLFilter{
+					ResourceTypeFilter:        entry.ResourceType,
+					ResourceNameFilter:        entry.ResourceName,
+					ResourcePatternTypeFilter: entry.ResourcePatternType,
+					PrincipalFilter:           entry.Principal,
+					HostFilter:                entry.Host,
+					Operation:                 entry.Operation,
+					PermissionType:            entry.PermissionType,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if response.Error != nil {
+				return response.Error
+			}
+			if found := len(response.Resources) > 0; found != present {
+				return fmt.Errorf("ACL %s on %s for %s is not propagated to %s yet",
+					entry.Operation, entry.ResourceName, entry.Principal, broker)
+			}
+		}
+	}
+	return nil
+}
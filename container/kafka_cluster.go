@@ -0,0 +1,233 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrBrokersMissing = errors.New("not all brokers are registered")
+	ErrInvalidSize    = errors.New("invalid number of nodes")
+)
+
+type kafkaCluster struct {
+	brokers []*kafka
+	topics  []TopicSpec
+	opts    KafkaOpts
+	pool    *dockertest.Pool
+	kafkaAccess
+	// invalid is returned on start if the options are unusable, e.g. without brokers.
+	invalid error
+}
+
+// KafkaClusterContainer is a Container running multiple kafka brokers.
+type KafkaClusterContainer interface {
+	KafkaContainer
+	// PauseBroker freezes the broker with the given id, e.g. to test leader failover.
+	PauseBroker(id int) error
+	// UnpauseBroker resumes a paused broker.
+	UnpauseBroker(id int) error
+}
+
+// WithKafkaCluster returns a Container in order to spawn n kafka brokers with the ids 1 to n.
+// Broker i is named "<ContainerName>-<i>" and reachable from the outside at ExternalPort+i-1.
+// In KRaft mode every broker is also a controller, otherwise the brokers register at zookeeper.
+// Topics and the internal topics are replicated to up to three brokers and require all but one
+// replica to be in sync, so producers with acks=all behave like in production.
+func WithKafkaCluster(n int, opts KafkaOpts, topics ...string) KafkaClusterContainer {
+	opts.ExternalHostName = validateHost(opts.ExternalHostName)
+	if opts.KRaft && opts.ClusterID == "" {
+		opts.ClusterID = newKafkaClusterID()
+	}
+	replicationFactor := n
+	if replicationFactor > 3 {
+		replicationFactor = 3
+	}
+	basePort, _ := strconv.Atoi(opts.ExternalPort)
+
+	var voters []string
+	hosts := []string{opts.ExternalHostName}
+	for id := 1; id <= n; id++ {
+		voters = append(voters, strconv.Itoa(id)+"@"+opts.ContainerName+"-"+strconv.Itoa(id)+":"+kafkaControllerPort)
+		hosts = append(hosts, opts.ContainerName+"-"+strconv.Itoa(id))
+	}
+	cluster := &kafkaCluster{
+		topics: topicSpecs(replicationFactor, opts.Topics, topics...),
+		opts:   opts,
+	}
+	cluster.kafkaAccess = kafkaAccess{
+		security:  newKafkaSecurity(opts.Security, hosts...),
+		addresses: cluster.Brokers,
+		admin:     cluster.adminClient,
+	}
+	if n < 1 {
+		cluster.invalid = fmt.Errorf("%w: a kafka cluster needs at least one broker, got %d", ErrInvalidSize, n)
+	}
+	for id := 1; id <= n; id++ {
+		brokerOpts := opts
+		brokerOpts.ContainerName = opts.ContainerName + "-" + strconv.Itoa(id)
+		brokerOpts.ExternalPort = strconv.Itoa(basePort + id - 1)
+		// Brokers are checked together, a KRaft broker is not ready before the quorum is complete.
+		cluster.brokers = append(cluster.brokers, newKafka(brokerOpts, kafkaNode{
+			id:                id,
+			quorumVoters:      strings.Join(voters, ","),
+			replicationFactor: replicationFactor,
+		}, nil, cluster.security))
+	}
+	return cluster
+}
+
+func (c *kafkaCluster) Brokers() []string {
+	brokers := make([]string, 0, len(c.brokers))
+	for _, broker := range c.brokers {
+		brokers = append(brokers, c.opts.ExternalHostName+":"+broker.port)
+	}
+	return brokers
+}
+
+func (c *kafkaCluster) GrantACL(ctx context.Context, acls ...KafkaACL) error {
+	client, err := c.adminClient()
+	if err != nil {
+		return err
+	}
+	return grantACLs(ctx, c.pool, client, c.Brokers(), acls)
+}
+
+func (c *kafkaCluster) RevokeACL(ctx context.Context, acls ...KafkaACL) error {
+	client, err := c.adminClient()
+	if err != nil {
+		return err
+	}
+	return revokeACLs(ctx, c.pool, client, c.Brokers(), acls)
+}
+
+func (c *kafkaCluster) PauseBroker(id int) error {
+	broker, err := c.broker(id)
+	if err != nil {
+		return err
+	}
+	return c.pool.Client.PauseContainer(broker.r.Container.ID)
+}
+
+func (c *kafkaCluster) UnpauseBroker(id int) error {
+	broker, err := c.broker(id)
+	if err != nil {
+		return err
+	}
+	return c.pool.Client.UnpauseContainer(broker.r.Container.ID)
+}
+
+func (c *kafkaCluster) broker(id int) (*kafka, error) {
+	if id < 1 || id > len(c.brokers) {
+		return nil, fmt.Errorf("broker %d does not exist", id)
+	}
+	broker := c.brokers[id-1]
+	if broker.r == nil {
+		return nil, ErrNotStarted
+	}
+	return broker, nil
+}
+
+// adminClient returns a client talking to the first broker, which forwards requests to the controller.
+func (c *kafkaCluster) adminClient() (*kafkaClient.Client, error) {
+	if c.invalid != nil {
+		return nil, c.invalid
+	}
+	return c.brokers[0].adminClient()
+}
+
+func (c *kafkaCluster) GetHostname() string {
+	if len(c.brokers) == 0 {
+		return ""
+	}
+	return c.brokers[0].GetHostname()
+}
+
+func (c *kafkaCluster) GetPorts() []int {
+	var ports []int
+	for _, broker := range c.brokers {
+		ports = append(ports, broker.GetPorts()...)
+	}
+	return ports
+}
+
+func (c *kafkaCluster) Size() int {
+	return len(c.brokers)
+}
+
+func (c *kafkaCluster) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if c.invalid != nil {
+		return c.invalid
+	}
+	c.pool = pool
+	for _, broker := range c.brokers {
+		if err := broker.Start(auth, pool); err != nil {
+			return err
+		}
+	}
+	// Credentials are stored cluster wide, so it is enough to create them at one broker.
+	first := c.brokers[0]
+	if err := c.security.createScramUsers(context.Background(), pool, first.r, first.internalPort); err != nil {
+		return err
+	}
+	dialer, err := c.Dialer(c.security.adminUser())
+	if err != nil {
+		return err
+	}
+	if err := pool.Retry(func() error {
+		return c.brokersRegistered(dialer)
+	}); err != nil {
+		return err
+	}
+	if len(c.topics) > 0 {
+		client, err := first.adminClient()
+		if err != nil {
+			return err
+		}
+		return createTopics(context.Background(), pool, client, c.topics)
+	}
+	return nil
+}
+
+// brokersRegistered checks that every broker is reachable and sees all brokers in the cluster metadata.
+func (c *kafkaCluster) brokersRegistered(dialer *kafkaClient.Dialer) error {
+	for _, address := range c.Brokers() {
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return err
+		}
+		brokers, err := conn.Brokers()
+		_ = conn.Close()
+		if err != nil {
+			return err
+		}
+		if len(brokers) != len(c.brokers) {
+			return fmt.Errorf("%w: %s knows %d of %d brokers", ErrBrokersMissing, address, len(brokers), len(c.brokers))
+		}
+	}
+	return nil
+}
+
+func (c *kafkaCluster) Stop() error {
+	c.recorders.finish()
+	var errs []error
+	for _, broker := range c.brokers {
+		if broker.r != nil {
+			errs = append(errs, broker.Stop())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *kafkaCluster) SetLabel(label map[string]string) {
+	for _, broker := range c.brokers {
+		broker.SetLabel(label)
+	}
+}
@@ -0,0 +1,344 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/cenkalti/backoff"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// kafkaConnectPluginDir is the directory inside the container the plugin directories are mounted to.
+const kafkaConnectPluginDir = "/etc/kafka-connect/plugins"
+
+var ErrConnectorFailed = errors.New("connector failed")
+
+// KafkaConnectImage is an image running a connect worker. Copy a predefined image to change the tag,
+// other images are configured like the confluent image.
+type KafkaConnectImage struct {
+	Repository string
+	Tag        string
+	// pluginPath lists the plugin directories of the image.
+	pluginPath string
+	// env turns the worker properties into the environment variables the image expects.
+	env func(config map[string]string) map[string]string
+}
+
+var (
+	// KafkaConnectImageConfluent is the plain worker of the confluent platform.
+	KafkaConnectImageConfluent = KafkaConnectImage{
+		Repository: "confluentinc/cp-kafka-connect",
+		Tag:        "7.6.1",
+		pluginPath: "/usr/share/java,/usr/share/confluent-hub-components",
+		env:        connectWorkerEnv,
+	}
+	// KafkaConnectImageDebezium ships the debezium source connectors.
+	KafkaConnectImageDebezium = KafkaConnectImage{
+		Repository: "quay.io/debezium/connect",
+		Tag:        "2.6",
+		pluginPath: "/kafka/connect",
+		env:        debeziumWorkerEnv,
+	}
+)
+
+type kafkaConnect struct {
+	hostName string
+	Port     int
+	Opts     testsetup.DockerContainerOpts
+	r        *dockertest.Resource
+	pool     *dockertest.Pool
+	opts     KafkaConnectOpts
+	kafka    testsetup.Container
+	// config holds the worker properties.
+	config map[string]string
+}
+
+// KafkaConnectContainer is a Container running a kafka connect worker.
+type KafkaConnectContainer interface {
+	testsetup.Container
+	// URL returns the address of the REST API reachable from the outside.
+	URL() string
+	// CreateConnector creates or updates the connector and waits until it and all its tasks are running.
+	CreateConnector(ctx context.Context, connector KafkaConnector) error
+	// DeleteConnector deletes the connector and its tasks.
+	DeleteConnector(ctx context.Context, name string) error
+	// ConnectorStatus returns the state of the connector and its tasks.
+	ConnectorStatus(ctx context.Context, name string) (*ConnectorStatus, error)
+}
+
+type KafkaConnectOpts struct {
+	ContainerName string
+	NetworkID     string
+	// KafkaContainerNamePort is the ContainerNamePort of the kafka container.
+	KafkaContainerNamePort string
+	// ExternalHost is the hostname the container is reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost string
+	ExternalPort string
+	// Image defaults to KafkaConnectImageConfluent.
+	Image KafkaConnectImage
+	// PluginDirs are mounted into the plugin path of the worker. Every directory holds a single plugin,
+	// e.g. an extracted connector archive. Mounts need a docker host sharing the filesystem, so not DinD.
+	PluginDirs []string
+	// WorkerConfig sets worker properties, e.g. {"value.converter": "io.confluent.connect.avro.AvroConverter"}.
+	// Converters default to the JSON converter.
+	WorkerConfig map[string]string
+	// Connectors are created in order once the REST API is ready.
+	Connectors []KafkaConnector
+}
+
+// KafkaConnector is a connector with its configuration as accepted by the REST API.
+type KafkaConnector struct {
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config"`
+}
+
+// ConnectorStatus is the state of a connector and its tasks.
+type ConnectorStatus struct {
+	Name      string           `json:"name"`
+	Connector ConnectorState   `json:"connector"`
+	Tasks     []ConnectorState `json:"tasks"`
+}
+
+// ConnectorState is the state of a connector or one of its tasks, e.g. RUNNING or FAILED.
+type ConnectorState struct {
+	// ID is the id of the task and zero for the connector.
+	ID    int    `json:"id"`
+	State string `json:"state"`
+	// Trace holds the stack trace of a failure.
+	Trace string `json:"trace"`
+}
+
+// LoadKafkaConnector reads a connector from a JSON file like {"name": "...", "config": {...}}.
+func LoadKafkaConnector(file string) (KafkaConnector, error) {
+	var connector KafkaConnector
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return connector, err
+	}
+	if err := json.Unmarshal(raw, &connector); err != nil {
+		return connector, fmt.Errorf("unable to parse connector %s: %w", file, err)
+	}
+	return connector, nil
+}
+
+// WithKafkaConnect returns a Container in order to spawn a kafka connect worker.
+// The kafka container is reached by its hostname within the network, so it has to be
+// started before the worker, i.e. passed before it to testsetup.NewTestSetup.
+func WithKafkaConnect(opts KafkaConnectOpts, kafka testsetup.Container) KafkaConnectContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	if opts.Image.Repository == "" {
+		opts.Image = KafkaConnectImageConfluent
+	}
+	if opts.Image.env == nil {
+		opts.Image.env = connectWorkerEnv
+	}
+	pluginPath := kafkaConnectPluginDir
+	if opts.Image.pluginPath != "" {
+		pluginPath = opts.Image.pluginPath + "," + pluginPath
+	}
+	port, _ := strconv.Atoi(opts.ExternalPort)
+	config := map[string]string{
+		"rest.port":                         "8083",
+		"rest.advertised.host.name":         opts.ContainerName,
+		"group.id":                          opts.ContainerName,
+		"config.storage.topic":              opts.ContainerName + "-configs",
+		"offset.storage.topic":              opts.ContainerName + "-offsets",
+		"status.storage.topic":              opts.ContainerName + "-status",
+		"config.storage.replication.factor": "1",
+		"offset.storage.replication.factor": "1",
+		"status.storage.replication.factor": "1",
+		"key.converter":                     "org.apache.kafka.connect.json.JsonConverter",
+		"value.converter":                   "org.apache.kafka.connect.json.JsonConverter",
+		"plugin.path":                       pluginPath,
+		// Commits source offsets quickly, so restarted connectors do not replay much.
+		"offset.flush.interval.ms": "1000",
+	}
+	for key, value := range opts.WorkerConfig {
+		config[key] = value
+	}
+	c := &kafkaConnect{
+		Port:   port,
+		opts:   opts,
+		kafka:  kafka,
+		config: config,
+		Opts: testsetup.DockerContainerOpts{
+			ContainerName: opts.ContainerName,
+			Repository:    opts.Image.Repository,
+			Tag:           opts.Image.Tag,
+			PortBinding:   map[string]string{opts.ExternalPort: "8083"},
+			ExpireTime:    5,
+			NetworkID:     opts.NetworkID,
+		},
+	}
+	c.Opts.HealthCheck = func(pool *dockertest.Pool, _ *dockertest.Resource) error {
+		if err := pool.Retry(func() error {
+			return requestJSON(context.Background(), http.MethodGet, c.URL()+"/connectors", "application/json", nil, nil)
+		}); err != nil {
+			return err
+		}
+		return nil
+	}
+	return c
+}
+
+// connectWorkerEnv turns worker properties into the environment variables of the image.
+// Dots become underscores, underscores double and dashes triple underscores.
+func connectWorkerEnv(config map[string]string) map[string]string {
+	replacer := strings.NewReplacer("-", "___", "_", "__", ".", "_")
+	env := make(map[string]string, len(config))
+	for key, value := range config {
+		env["CONNECT_"+strings.ToUpper(replacer.Replace(key))] = value
+	}
+	return env
+}
+
+// debeziumWorkerEnv turns worker properties into the environment variables of the debezium image, which only
+// turns underscores into dots and expects the required settings and some defaults without prefix.
+func debeziumWorkerEnv(config map[string]string) map[string]string {
+	env := make(map[string]string, len(config))
+	for key, value := range config {
+		env["CONNECT_"+strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = value
+	}
+	for name, key := range map[string]string{
+		"BOOTSTRAP_SERVERS":        "bootstrap.servers",
+		"GROUP_ID":                 "group.id",
+		"CONFIG_STORAGE_TOPIC":     "config.storage.topic",
+		"OFFSET_STORAGE_TOPIC":     "offset.storage.topic",
+		"STATUS_STORAGE_TOPIC":     "status.storage.topic",
+		"KEY_CONVERTER":            "key.converter",
+		"VALUE_CONVERTER":          "value.converter",
+		"OFFSET_FLUSH_INTERVAL_MS": "offset.flush.interval.ms",
+	} {
+		if value, ok := config[key]; ok {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+func (c *kafkaConnect) URL() string {
+	return "http://" + c.opts.ExternalHost + ":" + c.opts.ExternalPort
+}
+
+func (c *kafkaConnect) CreateConnector(ctx context.Context, connector KafkaConnector) error {
+	if err := requestJSON(ctx, http.MethodPut, c.connectorURL(connector.Name)+"/config", "application/json",
+		connector.Config, nil); err != nil {
+		return fmt.Errorf("unable to create connector %s: %w", connector.Name, err)
+	}
+	return c.pool.Retry(func() error {
+		status, err := c.ConnectorStatus(ctx, connector.Name)
+		if err != nil {
+			return err
+		}
+		if err := status.failure(); err != nil {
+			return backoff.Permanent(err)
+		}
+		return status.running()
+	})
+}
+
+func (c *kafkaConnect) DeleteConnector(ctx context.Context, name string) error {
+	return requestJSON(ctx, http.MethodDelete, c.connectorURL(name), "application/json", nil, nil)
+}
+
+func (c *kafkaConnect) ConnectorStatus(ctx context.Context, name string) (*ConnectorStatus, error) {
+	var status ConnectorStatus
+	if err := requestJSON(ctx, http.MethodGet, c.connectorURL(name)+"/status", "application/json", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *kafkaConnect) connectorURL(name string) string {
+	return c.URL() + "/connectors/" + url.PathEscape(name)
+}
+
+// failure returns an error including the trace if the connector or one of its tasks failed.
+func (s *ConnectorStatus) failure() error {
+	if s.Connector.State == "FAILED" {
+		return fmt.Errorf("%w: %s: %s", ErrConnectorFailed, s.Name, s.Connector.Trace)
+	}
+	for _, task := range s.Tasks {
+		if task.State == "FAILED" {
+			return fmt.Errorf("%w: task %d of %s: %s", ErrConnectorFailed, task.ID, s.Name, task.Trace)
+		}
+	}
+	return nil
+}
+
+// running returns an error unless the connector and at least one task are running.
+func (s *ConnectorStatus) running() error {
+	if s.Connector.State != "RUNNING" {
+		return fmt.Errorf("connector %s is %s", s.Name, s.Connector.State)
+	}
+	if len(s.Tasks) == 0 {
+		return fmt.Errorf("connector %s has no tasks yet", s.Name)
+	}
+	for _, task := range s.Tasks {
+		if task.State != "RUNNING" {
+			return fmt.Errorf("task %d of %s is %s", task.ID, s.Name, task.State)
+		}
+	}
+	return nil
+}
+
+func (c *kafkaConnect) GetHostname() string {
+	return c.hostName
+}
+
+func (c *kafkaConnect) GetPorts() []int {
+	return []int{c.Port}
+}
+
+func (c *kafkaConnect) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if c.opts.KafkaContainerNamePort == "" {
+		return fmt.Errorf("%w: kafka connect needs KafkaContainerNamePort", ErrInvalidPort)
+	}
+	c.config["bootstrap.servers"] = c.kafka.GetHostname() + ":" + c.opts.KafkaContainerNamePort
+	c.Opts.Env = c.opts.Image.env(c.config)
+	if len(c.opts.PluginDirs) > 0 {
+		c.Opts.Mounts = map[string]string{}
+		for i, dir := range c.opts.PluginDirs {
+			source, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			c.Opts.Mounts[source] = kafkaConnectPluginDir + "/" + strconv.Itoa(i) + "-" + filepath.Base(source)
+		}
+	}
+	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, c.Opts)
+	if err != nil {
+		return err
+	}
+	c.hostName = *hostname
+	c.r = resource
+	c.pool = pool
+	for _, connector := range c.opts.Connectors {
+		if err := c.CreateConnector(context.Background(), connector); err != nil {
+			_ = resource.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *kafkaConnect) Stop() error {
+	return c.r.Close()
+}
+
+func (c *kafkaConnect) SetLabel(label map[string]string) {
+	c.Opts.Labels = label
+}
@@ -0,0 +1,74 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectWorkerEnv(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"CONNECT_KEY_CONVERTER":               "json",
+		"CONNECT_CONFIG_STORAGE_TOPIC":        "configs",
+		"CONNECT_CONSUMER_MAX_POLL__RECORDS":  "1",
+		"CONNECT_CONNECTOR_CLIENT___OVERRIDE": "All",
+	}, connectWorkerEnv(map[string]string{
+		"key.converter":             "json",
+		"config.storage.topic":      "configs",
+		"consumer.max.poll_records": "1",
+		"connector.client-override": "All",
+	}))
+}
+
+func TestKafkaConnectRequiresKafkaPort(t *testing.T) {
+	kafka := WithKafka(KafkaOpts{ContainerName: "kafka"})
+	connect := WithKafkaConnect(KafkaConnectOpts{ContainerName: "connect", ExternalPort: "8083"}, kafka)
+	assert.ErrorIs(t, connect.Start(docker.AuthConfiguration{}, nil), ErrInvalidPort)
+}
+
+func TestConnectorStatus(t *testing.T) {
+	status := ConnectorStatus{Name: "sink", Connector: ConnectorState{State: "RUNNING"}}
+	assert.NoError(t, status.failure())
+	assert.ErrorContains(t, status.running(), "no tasks")
+
+	status.Tasks = []ConnectorState{{ID: 0, State: "RUNNING"}, {ID: 1, State: "UNASSIGNED"}}
+	assert.ErrorContains(t, status.running(), "task 1 of sink is UNASSIGNED")
+
+	status.Tasks[1] = ConnectorState{ID: 1, State: "RUNNING"}
+	assert.NoError(t, status.running())
+
+	status.Tasks[0] = ConnectorState{ID: 0, State: "FAILED", Trace: "java.sql.SQLException"}
+	err := status.failure()
+	assert.ErrorIs(t, err, ErrConnectorFailed)
+	assert.ErrorContains(t, err, "java.sql.SQLException")
+}
+
+func TestLoadKafkaConnector(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sink.json")
+	require.NoError(t, os.WriteFile(file,
+		[]byte(`{"name": "sink", "config": {"connector.class": "JdbcSinkConnector", "tasks.max": "1"}}`), 0600))
+	connector, err := LoadKafkaConnector(file)
+	require.NoError(t, err)
+	assert.Equal(t, KafkaConnector{
+		Name:   "sink",
+		Config: map[string]string{"connector.class": "JdbcSinkConnector", "tasks.max": "1"},
+	}, connector)
+}
+
+func TestDebeziumWorkerEnv(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"CONNECT_BOOTSTRAP_SERVERS": "kafka:9091",
+		"CONNECT_REST_PORT":         "8083",
+		"CONNECT_KEY_CONVERTER":     "json",
+		"BOOTSTRAP_SERVERS":         "kafka:9091",
+		"KEY_CONVERTER":             "json",
+	}, debeziumWorkerEnv(map[string]string{
+		"bootstrap.servers": "kafka:9091",
+		"rest.port":         "8083",
+		"key.converter":     "json",
+	}))
+}
@@ -0,0 +1,100 @@
+package container
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrNoWireFormat = errors.New("value is not in the schema registry wire format")
+
+// ValueDecoder decodes a message value into the types produced by encoding/json,
+// so it can be matched with ValuePath.
+type ValueDecoder func(value []byte) (any, error)
+
+// JSONDecoder decodes JSON values.
+func JSONDecoder(value []byte) (any, error) {
+	var decoded any
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// AvroDecoder returns a decoder for avro values written with the schema in the schema registry wire format.
+// Unions are decoded to their plain value instead of the {"type": value} avro JSON encoding.
+func AvroDecoder(schema string) (ValueDecoder, error) {
+	codec, err := goavro.NewCodecForStandardJSONFull(schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(value []byte) (any, error) {
+		_, payload, err := wireFormatPayload(value)
+		if err != nil {
+			return nil, err
+		}
+		native, _, err := codec.NativeFromBinary(payload)
+		if err != nil {
+			return nil, err
+		}
+		textual, err := codec.TextualFromNative(nil, native)
+		if err != nil {
+			return nil, err
+		}
+		return JSONDecoder(textual)
+	}, nil
+}
+
+// ProtobufDecoder returns a decoder for protobuf values of the message type in the schema registry wire format.
+// Fields are named as in the .proto file and unset fields are decoded to their zero value.
+func ProtobufDecoder(message proto.Message) ValueDecoder {
+	return func(value []byte) (any, error) {
+		_, payload, err := wireFormatPayload(value)
+		if err != nil {
+			return nil, err
+		}
+		payload, err = skipMessageIndexes(payload)
+		if err != nil {
+			return nil, err
+		}
+		decoded := message.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(payload, decoded); err != nil {
+			return nil, err
+		}
+		textual, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(decoded)
+		if err != nil {
+			return nil, err
+		}
+		return JSONDecoder(textual)
+	}
+}
+
+// wireFormatPayload splits a value into the schema id and the payload behind the magic byte and the id.
+func wireFormatPayload(value []byte) (int, []byte, error) {
+	if len(value) < 5 || value[0] != 0 {
+		return 0, nil, ErrNoWireFormat
+	}
+	return int(binary.BigEndian.Uint32(value[1:5])), value[5:], nil
+}
+
+// skipMessageIndexes removes the indexes of the message type within the .proto file written before protobuf payloads.
+// A single zero stands for the first message type.
+func skipMessageIndexes(payload []byte) ([]byte, error) {
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 {
+		return nil, fmt.Errorf("%w: invalid message indexes", ErrNoWireFormat)
+	}
+	payload = payload[n:]
+	for i := int64(0); i < count; i++ {
+		if _, n = binary.Varint(payload); n <= 0 {
+			return nil, fmt.Errorf("%w: invalid message indexes", ErrNoWireFormat)
+		}
+		payload = payload[n:]
+	}
+	return payload, nil
+}
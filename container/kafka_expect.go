@@ -0,0 +1,654 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+// expectReportLimit is the number of seen messages listed in a failure report.
+const expectReportLimit = 20
+
+// ExpectOpts configures how ExpectMessagesWith consumes a topic.
+type ExpectOpts struct {
+	// FromOffset is the offset every partition is read from. Defaults to the first offset.
+	FromOffset int64
+	// FromTime reads every partition from the first message produced at or after the time. Overrides FromOffset.
+	FromTime time.Time
+	// Timeout to wait for the expected messages. Defaults to 10 seconds.
+	Timeout time.Duration
+	// InOrder requires the messages to arrive in the order of the matchers.
+	// Kafka only orders messages within a partition, so use it with a single partition or messages of the same key.
+	InOrder bool
+	// Exactly fails if the topic contains messages not matched by any matcher.
+	Exactly bool
+	// Decoder decodes the values for ValuePath and Value. Defaults to JSONDecoder.
+	Decoder ValueDecoder
+	// User to consume as if SASL is enabled. Defaults to the admin user.
+	User string
+}
+
+// ReceivedMessage is a consumed message together with its decoded value.
+type ReceivedMessage struct {
+	kafkaClient.Message
+	// Decoded is the value decoded by ExpectOpts.Decoder.
+	Decoded any
+	// DecodeErr is set if the value could not be decoded.
+	DecodeErr error
+}
+
+// MessageCondition checks a single property of a message and describes the mismatch if it does not hold.
+type MessageCondition func(msg ReceivedMessage) error
+
+// MessageMatcher expects a message fulfilling all of its conditions.
+type MessageMatcher struct {
+	name       string
+	times      int
+	conditions []MessageCondition
+}
+
+// Message returns a matcher expecting one message fulfilling all conditions.
+func Message(conditions ...MessageCondition) MessageMatcher {
+	return MessageMatcher{times: 1, conditions: conditions}
+}
+
+// Times expects n messages fulfilling the conditions.
+func (m MessageMatcher) Times(n int) MessageMatcher {
+	m.times = n
+	return m
+}
+
+// Named sets the name the matcher is reported with.
+func (m MessageMatcher) Named(name string) MessageMatcher {
+	m.name = name
+	return m
+}
+
+func (m MessageMatcher) String() string {
+	if m.name != "" {
+		return m.name
+	}
+	return fmt.Sprintf("message with %d conditions", len(m.conditions))
+}
+
+// mismatch returns the first condition the message does not fulfill.
+func (m MessageMatcher) mismatch(msg ReceivedMessage) error {
+	for _, condition := range m.conditions {
+		if err := condition(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key expects the message key.
+func Key(key string) MessageCondition {
+	return func(msg ReceivedMessage) error {
+		if string(msg.Key) != key {
+			return fmt.Errorf("key: expected %q, got %q", key, msg.Key)
+		}
+		return nil
+	}
+}
+
+// Header expects a header with the value.
+func Header(key string, value string) MessageCondition {
+	return func(msg ReceivedMessage) error {
+		var values []string
+		for _, header := range msg.Headers {
+			if header.Key != key {
+				continue
+			}
+			if string(header.Value) == value {
+				return nil
+			}
+			values = append(values, strconv.Quote(string(header.Value)))
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("header %s: expected %q, got none", key, value)
+		}
+		return fmt.Errorf("header %s: expected %q, got %s", key, value, strings.Join(values, ", "))
+	}
+}
+
+// Value expects the decoded value. The expected value is compared by its JSON representation,
+// so structs, maps and numbers of any type can be used.
+func Value(expected any) MessageCondition {
+	return ValuePath("$", expected)
+}
+
+// ValuePath expects the decoded value at a JSON path like "$.after.items[0].id".
+// The expected value is compared by its JSON representation.
+func ValuePath(path string, expected any) MessageCondition {
+	return func(msg ReceivedMessage) error {
+		if msg.DecodeErr != nil {
+			return fmt.Errorf("%s: value not decodable: %w", path, msg.DecodeErr)
+		}
+		actual, err := jsonPath(msg.Decoded, path)
+		if err != nil {
+			return err
+		}
+		want, err := normalizeJSON(expected)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		got, err := normalizeJSON(actual)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			return fmt.Errorf("%s: expected %s, got %s", path, compactJSON(want), compactJSON(got))
+		}
+		return nil
+	}
+}
+
+// normalizeJSON converts a value to the types produced by encoding/json.
+func normalizeJSON(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func compactJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// jsonPath resolves a path of the form $.field.nested[0]["quoted field"] within a decoded value.
+func jsonPath(value any, path string) (any, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%s: path has to start with $", path)
+	}
+	rest := path[1:]
+	current := value
+	for rest != "" {
+		var field string
+		index := -1
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			field, rest = rest[1:end+1], rest[end+1:]
+			if field == "" {
+				return nil, fmt.Errorf("%s: empty field name", path)
+			}
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unterminated field name", path)
+			}
+			field, rest = rest[2:end], rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unterminated index", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("%s: invalid index %q", path, rest[1:end])
+			}
+			index, rest = i, rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%s: unexpected %q", path, rest)
+		}
+		resolved := path[:len(path)-len(rest)]
+		if index >= 0 {
+			list, ok := current.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: not a list", resolved)
+			}
+			if index >= len(list) {
+				return nil, fmt.Errorf("%s: index out of range, list has %d elements", resolved, len(list))
+			}
+			current = list[index]
+			continue
+		}
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an object", resolved)
+		}
+		current, ok = object[field]
+		if !ok {
+			return nil, fmt.Errorf("%s: missing", resolved)
+		}
+	}
+	return current, nil
+}
+
+// messageExpectation assigns consumed messages to the matchers.
+type messageExpectation struct {
+	matchers []MessageMatcher
+	inOrder  bool
+	// slots holds a matcher index per expected message, repeated Times times.
+	slots []int
+	// matched holds the index in seen of the message assigned to each slot, or -1 if the slot is open.
+	matched []int
+	next    int
+	seen    []ReceivedMessage
+	// unmatched holds the indexes in seen of messages not assigned to a slot.
+	unmatched []int
+}
+
+func newMessageExpectation(matchers []MessageMatcher, inOrder bool) *messageExpectation {
+	e := &messageExpectation{matchers: matchers, inOrder: inOrder}
+	for i, matcher := range matchers {
+		for n := 0; n < matcher.times; n++ {
+			e.slots = append(e.slots, i)
+			e.matched = append(e.matched, -1)
+		}
+	}
+	return e
+}
+
+// add assigns the message to an open slot it matches, or the next slot if the order matters.
+func (e *messageExpectation) add(msg ReceivedMessage) {
+	e.seen = append(e.seen, msg)
+	index := len(e.seen) - 1
+	if e.inOrder {
+		if e.next < len(e.slots) && e.matchers[e.slots[e.next]].mismatch(msg) == nil {
+			e.matched[e.next] = index
+			e.next++
+			return
+		}
+	} else if e.assign(index, map[int]bool{}) {
+		return
+	}
+	e.unmatched = append(e.unmatched, index)
+}
+
+// assign finds a slot for the message in seen. If all slots it matches are taken, it tries to move their messages
+// to other slots, so a general matcher listed first does not keep a message from the specific matcher needing it.
+func (e *messageExpectation) assign(index int, visited map[int]bool) bool {
+	var taken []int
+	for slot, matcher := range e.slots {
+		if visited[slot] || e.matchers[matcher].mismatch(e.seen[index]) != nil {
+			continue
+		}
+		if e.matched[slot] < 0 {
+			e.matched[slot] = index
+			return true
+		}
+		taken = append(taken, slot)
+	}
+	for _, slot := range taken {
+		if visited[slot] {
+			continue
+		}
+		visited[slot] = true
+		if e.assign(e.matched[slot], visited) {
+			e.matched[slot] = index
+			return true
+		}
+	}
+	return false
+}
+
+func (e *messageExpectation) satisfied() bool {
+	for _, index := range e.matched {
+		if index < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// messages returns the matched messages in the order of the matchers.
+func (e *messageExpectation) messages() []ReceivedMessage {
+	messages := make([]ReceivedMessage, 0, len(e.matched))
+	for _, index := range e.matched {
+		if index >= 0 {
+			messages = append(messages, e.seen[index])
+		}
+	}
+	return messages
+}
+
+// report describes which matchers are fulfilled and why the unmatched messages did not match the open ones.
+func (e *messageExpectation) report() string {
+	var b strings.Builder
+	b.WriteString("expected:\n")
+	for slot, matcher := range e.slots {
+		if index := e.matched[slot]; index >= 0 {
+			msg := e.seen[index]
+			fmt.Fprintf(&b, "  + %s: partition %d offset %d\n", e.matchers[matcher], msg.Partition, msg.Offset)
+		} else {
+			fmt.Fprintf(&b, "  - %s: missing\n", e.matchers[matcher])
+		}
+	}
+	fmt.Fprintf(&b, "seen %d messages, %d unmatched", len(e.seen), len(e.unmatched))
+	unmatched := e.unmatched
+	if len(unmatched) > expectReportLimit {
+		fmt.Fprintf(&b, ", showing the last %d", expectReportLimit)
+		unmatched = unmatched[len(unmatched)-expectReportLimit:]
+	}
+	b.WriteString(":\n")
+	for _, i := range unmatched {
+		msg := e.seen[i]
+		fmt.Fprintf(&b, "  partition %d offset %d key %q%s value %s\n", msg.Partition, msg.Offset, msg.Key,
+			formatHeaders(msg.Headers), truncate(string(msg.Value), 200))
+		reported := map[int]bool{}
+		for slot, matcher := range e.slots {
+			if e.matched[slot] >= 0 || reported[matcher] {
+				continue
+			}
+			reported[matcher] = true
+			if err := e.matchers[matcher].mismatch(msg); err != nil {
+				fmt.Fprintf(&b, "      %s: %v\n", e.matchers[matcher], err)
+			}
+		}
+	}
+	return b.String()
+}
+
+func formatHeaders(headers []kafkaClient.Header) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	formatted := make([]string, 0, len(headers))
+	for _, header := range headers {
+		formatted = append(formatted, header.Key+"="+strconv.Quote(string(header.Value)))
+	}
+	return " headers [" + strings.Join(formatted, " ") + "]"
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// expectMessagesAs dials as the user of the opts or the admin user and expects the messages.
+func expectMessagesAs(t testing.TB, security *kafkaSecurity, brokers []string, topic string, opts ExpectOpts,
+	matchers []MessageMatcher) []ReceivedMessage {
+	t.Helper()
+	user := opts.User
+	if user == "" {
+		user = security.adminUser()
+	}
+	dialer, err := security.dialer(user)
+	if err != nil {
+		t.Fatalf("unable to consume topic %s: %v", topic, err)
+	}
+	return expectMessages(t, brokers, dialer, topic, opts, matchers)
+}
+
+// expectMessages consumes all partitions of the topic until the matchers are satisfied and fails the test otherwise.
+func expectMessages(t testing.TB, brokers []string, dialer *kafkaClient.Dialer, topic string, opts ExpectOpts,
+	matchers []MessageMatcher) []ReceivedMessage {
+	t.Helper()
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Decoder == nil {
+		opts.Decoder = JSONDecoder
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	expectation := newMessageExpectation(matchers, opts.InOrder)
+	err := consumeTopic(ctx, brokers, dialer, topic, opts, expectation)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected messages on topic %s not seen within %s\n%s", topic, opts.Timeout, expectation.report())
+	}
+	if err != nil {
+		t.Fatalf("unable to consume topic %s: %v", topic, err)
+	}
+	if opts.Exactly && len(expectation.unmatched) > 0 {
+		t.Fatalf("topic %s contains unexpected messages\n%s", topic, expectation.report())
+	}
+	return expectation.messages()
+}
+
+// consumeTopic reads every partition until the expectation is satisfied. With Exactly it continues
+// until the end offsets at that point are reached, so extra messages are seen as well.
+func consumeTopic(ctx context.Context, brokers []string, dialer *kafkaClient.Dialer, topic string, opts ExpectOpts,
+	expectation *messageExpectation) error {
+	partitions, err := topicPartitions(ctx, brokers[0], dialer, topic)
+	if err != nil {
+		return err
+	}
+	starts, err := partitionBounds(ctx, brokers[0], dialer, topic, partitions, opts)
+	if err != nil {
+		return err
+	}
+	readerCtx, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer stop()
+	batches := make(chan partitionBatch)
+	errs := make(chan error, len(partitions))
+	for _, partition := range partitions {
+		wg.Add(1)
+		go func(partition int) {
+			defer wg.Done()
+			errs <- readBatches(readerCtx, dialer, brokers[0], topic, partition, starts[partition].start,
+				func(batch partitionBatch) error {
+					select {
+					case batches <- batch:
+						return nil
+					case <-readerCtx.Done():
+						return readerCtx.Err()
+					}
+				})
+		}(partition)
+	}
+	positions := map[int]int64{}
+	var bounds map[int]offsetRange
+	for {
+		if expectation.satisfied() {
+			if !opts.Exactly {
+				return nil
+			}
+			if bounds == nil {
+				if bounds, err = partitionBounds(ctx, brokers[0], dialer, topic, partitions, opts); err != nil {
+					return err
+				}
+			}
+			if reached(positions, bounds) {
+				return nil
+			}
+		}
+		select {
+		case batch := <-batches:
+			positions[batch.partition] = batch.next
+			for _, msg := range batch.messages {
+				value, err := opts.Decoder(msg.Value)
+				expectation.add(ReceivedMessage{Message: msg, Decoded: value, DecodeErr: err})
+			}
+		case err := <-errs:
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// partitionBatch holds the messages of one fetch from a partition and the offset the next fetch starts at.
+// The offset moves past transaction markers, which are never returned as message, so it reaches the end
+// offset of a partition written by a transactional producer.
+type partitionBatch struct {
+	partition int
+	messages  []kafkaClient.Message
+	next      int64
+}
+
+// readBatches reads the partition from the offset and passes every fetch to fn until the context is done.
+// Temporary errors, e.g. while the leader moves to another broker, are retried from the last offset.
+func readBatches(ctx context.Context, dialer *kafkaClient.Dialer, broker string, topic string, partition int,
+	offset int64, fn func(batch partitionBatch) error) error {
+	for {
+		err := readBatchesFromLeader(ctx, dialer, broker, topic, partition, &offset, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether reading a partition can continue on a new connection after the error.
+func retryable(err error) bool {
+	var kafkaErr kafkaClient.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func readBatchesFromLeader(ctx context.Context, dialer *kafkaClient.Dialer, broker string, topic string,
+	partition int, offset *int64, fn func(batch partitionBatch) error) error {
+	conn, err := dialer.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Seek(*offset, kafkaClient.SeekAbsolute); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+			return err
+		}
+		fetched := conn.ReadBatchWith(kafkaClient.ReadBatchConfig{
+			MinBytes: 1,
+			MaxBytes: 10e6,
+			MaxWait:  250 * time.Millisecond,
+		})
+		batch := partitionBatch{partition: partition}
+		for {
+			msg, err := fetched.ReadMessage()
+			if err != nil {
+				break
+			}
+			batch.messages = append(batch.messages, msg)
+		}
+		if err := fetched.Close(); err != nil {
+			return err
+		}
+		batch.next, _ = conn.Offset()
+		*offset = batch.next
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// topicPartitions returns the partitions of the topic and waits for topics created on first use.
+func topicPartitions(ctx context.Context, broker string, dialer *kafkaClient.Dialer, topic string) ([]int, error) {
+	for {
+		partitions, err := readPartitions(ctx, broker, dialer, topic)
+		if err == nil && len(partitions) > 0 {
+			return partitions, nil
+		}
+		if err != nil && !errors.Is(err, kafkaClient.UnknownTopicOrPartition) {
+			return nil, err
+		}
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("topic %s does not exist: %w", topic, ctx.Err())
+		}
+	}
+}
+
+func readPartitions(ctx context.Context, broker string, dialer *kafkaClient.Dialer, topic string) ([]int, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	found, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]int, 0, len(found))
+	for _, partition := range found {
+		partitions = append(partitions, partition.ID)
+	}
+	sort.Ints(partitions)
+	return partitions, nil
+}
+
+// offsetRange is the range of offsets of a partition to consume.
+type offsetRange struct {
+	start int64
+	end   int64
+}
+
+// partitionBounds returns the start offset according to the opts and the current end offset of every partition.
+func partitionBounds(ctx context.Context, broker string, dialer *kafkaClient.Dialer, topic string, partitions []int,
+	opts ExpectOpts) (map[int]offsetRange, error) {
+	bounds := map[int]offsetRange{}
+	for _, partition := range partitions {
+		conn, err := dialer.DialLeader(ctx, "tcp", broker, topic, partition)
+		if err != nil {
+			return nil, err
+		}
+		var bound offsetRange
+		switch {
+		case !opts.FromTime.IsZero():
+			bound.start, err = conn.ReadOffset(opts.FromTime)
+		case opts.FromOffset > 0:
+			bound.start = opts.FromOffset
+		default:
+			bound.start, err = conn.ReadFirstOffset()
+		}
+		if err == nil {
+			bound.end, err = conn.ReadLastOffset()
+		}
+		if bound.start < 0 {
+			// There is no message after FromTime yet.
+			bound.start = bound.end
+		}
+		_ = conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		bounds[partition] = bound
+	}
+	return bounds, nil
+}
+
+// reached checks that every partition is consumed up to its end offset.
+func reached(positions map[int]int64, bounds map[int]offsetRange) bool {
+	for partition, bound := range bounds {
+		if bound.start < bound.end && positions[partition] < bound.end {
+			return false
+		}
+	}
+	return true
+}
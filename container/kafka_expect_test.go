@@ -0,0 +1,167 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+	kafkaClient "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func received(key string, value string, headers ...kafkaClient.Header) ReceivedMessage {
+	decoded, err := JSONDecoder([]byte(value))
+	return ReceivedMessage{
+		Message:   kafkaClient.Message{Key: []byte(key), Value: []byte(value), Headers: headers},
+		Decoded:   decoded,
+		DecodeErr: err,
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	value, err := JSONDecoder([]byte(`{"after": {"id": 1, "items": [{"sku": "a"}, {"sku": "b"}], "odd key": true}}`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path     string
+		expected any
+		err      string
+	}{
+		{path: "$", expected: value},
+		{path: "$.after.id", expected: float64(1)},
+		{path: "$.after.items[1].sku", expected: "b"},
+		{path: `$.after["odd key"]`, expected: true},
+		{path: "$.after.missing", err: "$.after.missing: missing"},
+		{path: "$.after.items[2]", err: "$.after.items[2]: index out of range, list has 2 elements"},
+		{path: "$.after.id.nested", err: "$.after.id.nested: not an object"},
+		{path: "after.id", err: "after.id: path has to start with $"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			actual, err := jsonPath(value, test.path)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestMessageConditions(t *testing.T) {
+	msg := received("1", `{"status": "paid", "amount": 10}`, kafkaClient.Header{Key: "type", Value: []byte("order")})
+
+	assert.NoError(t, Key("1")(msg))
+	assert.EqualError(t, Key("2")(msg), `key: expected "2", got "1"`)
+	assert.NoError(t, Header("type", "order")(msg))
+	assert.EqualError(t, Header("type", "user")(msg), `header type: expected "user", got "order"`)
+	assert.EqualError(t, Header("trace", "x")(msg), `header trace: expected "x", got none`)
+	assert.NoError(t, ValuePath("$.amount", 10)(msg))
+	assert.NoError(t, Value(map[string]any{"status": "paid", "amount": 10})(msg))
+	assert.EqualError(t, ValuePath("$.status", "open")(msg), `$.status: expected "open", got "paid"`)
+	assert.ErrorContains(t, ValuePath("$", nil)(received("1", "not json")), "value not decodable")
+}
+
+func TestMessageExpectation(t *testing.T) {
+	created := Message(ValuePath("$.status", "created")).Named("created")
+	paid := Message(ValuePath("$.status", "paid")).Named("paid")
+
+	t.Run("unordered", func(t *testing.T) {
+		e := newMessageExpectation([]MessageMatcher{created, paid.Times(2)}, false)
+		e.add(received("1", `{"status": "paid"}`))
+		e.add(received("2", `{"status": "created"}`))
+		assert.False(t, e.satisfied())
+		e.add(received("3", `{"status": "paid"}`))
+		assert.True(t, e.satisfied())
+		assert.Empty(t, e.unmatched)
+
+		keys := []string{}
+		for _, msg := range e.messages() {
+			keys = append(keys, string(msg.Key))
+		}
+		assert.Equal(t, []string{"2", "1", "3"}, keys)
+	})
+
+	t.Run("specific matcher listed last", func(t *testing.T) {
+		e := newMessageExpectation([]MessageMatcher{
+			Message(Key("a")).Named("plain"),
+			Message(Key("a"), Header("x", "1")).Named("with header"),
+		}, false)
+		e.add(received("a", `{}`, kafkaClient.Header{Key: "x", Value: []byte("1")}))
+		assert.False(t, e.satisfied())
+		e.add(received("a", `{}`))
+		assert.True(t, e.satisfied())
+		assert.Empty(t, e.unmatched)
+
+		messages := e.messages()
+		require.Len(t, messages, 2)
+		assert.Empty(t, messages[0].Headers, "the plain message moves the header message to the specific matcher")
+		assert.Len(t, messages[1].Headers, 1)
+	})
+
+	t.Run("in order", func(t *testing.T) {
+		e := newMessageExpectation([]MessageMatcher{created, paid}, true)
+		e.add(received("1", `{"status": "paid"}`))
+		e.add(received("1", `{"status": "created"}`))
+		assert.False(t, e.satisfied())
+		e.add(received("1", `{"status": "paid"}`))
+		assert.True(t, e.satisfied())
+		assert.Equal(t, []int{0}, e.unmatched)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		e := newMessageExpectation([]MessageMatcher{created, paid}, false)
+		e.add(received("1", `{"status": "created"}`))
+		e.add(received("2", `{"status": "shipped"}`, kafkaClient.Header{Key: "type", Value: []byte("order")}))
+
+		report := e.report()
+		assert.Contains(t, report, "+ created: partition 0 offset 0")
+		assert.Contains(t, report, "- paid: missing")
+		assert.Contains(t, report, "seen 2 messages, 1 unmatched:")
+		assert.Contains(t, report, `key "2" headers [type="order"] value {"status": "shipped"}`)
+		assert.Contains(t, report, `paid: $.status: expected "paid", got "shipped"`)
+	})
+}
+
+func TestAvroDecoder(t *testing.T) {
+	schema := `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "note", "type": ["null", "string"], "default": null}
+	]}`
+	codec, err := goavro.NewCodec(schema)
+	require.NoError(t, err)
+	payload, err := codec.BinaryFromNative(nil, map[string]any{"id": int64(7), "note": goavro.Union("string", "hi")})
+	require.NoError(t, err)
+
+	decoder, err := AvroDecoder(schema)
+	require.NoError(t, err)
+	decoded, err := decoder(append([]byte{0, 0, 0, 0, 1}, payload...))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"id": float64(7), "note": "hi"}, decoded)
+
+	_, err = decoder(payload)
+	assert.ErrorIs(t, err, ErrNoWireFormat)
+	_, err = AvroDecoder(`{"type": "unknown"}`)
+	assert.Error(t, err)
+}
+
+func TestProtobufDecoder(t *testing.T) {
+	message, err := structpb.NewStruct(map[string]any{"status": "paid", "items": []any{"a"}})
+	require.NoError(t, err)
+	payload, err := proto.Marshal(message)
+	require.NoError(t, err)
+
+	decoder := ProtobufDecoder(&structpb.Struct{})
+	// Magic byte, schema id 1 and the message indexes [0] encoded as a single zero.
+	decoded, err := decoder(append([]byte{0, 0, 0, 0, 1, 0}, payload...))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"status": "paid", "items": []any{"a"}}, decoded)
+
+	// Message indexes [1, 0] of a nested message type.
+	decoded, err = decoder(append([]byte{0, 0, 0, 0, 1, 4, 2, 0}, payload...))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"status": "paid", "items": []any{"a"}}, decoded)
+}
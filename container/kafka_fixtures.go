@@ -0,0 +1,322 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+// fixtureMagic starts every binary fixture file.
+const fixtureMagic = "testsetup-kafka-fixture-v1\n"
+
+// fixtureBatchSize is the number of records produced at once when seeding.
+const fixtureBatchSize = 1000
+
+var ErrInvalidFixture = errors.New("invalid kafka fixture")
+
+// fixtureBalancer produces records to the partition they were captured from. Records of a partition the topic
+// does not have are partitioned like the java producer does when keyed and wrap around otherwise.
+type fixtureBalancer struct{}
+
+func (fixtureBalancer) Balance(msg kafkaClient.Message, partitions ...int) int {
+	switch {
+	case msg.Partition >= 0 && msg.Partition < len(partitions):
+		return partitions[msg.Partition]
+	case len(msg.Key) > 0:
+		return kafkaClient.Murmur2Balancer{}.Balance(msg, partitions...)
+	default:
+		return partitions[(msg.Partition%len(partitions)+len(partitions))%len(partitions)]
+	}
+}
+
+// message returns the record as message to produce. The timestamp is left to the producer, records keeping
+// their original timestamp might be deleted right away by the retention of the topic.
+func (r KafkaRecord) message() kafkaClient.Message {
+	msg := kafkaClient.Message{Partition: r.Partition, Key: r.Key, Value: r.Value}
+	for _, header := range r.Headers {
+		msg.Headers = append(msg.Headers, kafkaClient.Header{Key: header.Key, Value: header.Value})
+	}
+	return msg
+}
+
+// seedTopics produces the fixtures of the specs into their topics.
+func seedTopics(ctx context.Context, client *kafkaClient.Client, specs []TopicSpec) error {
+	for _, spec := range specs {
+		if len(spec.Fixtures) == 0 {
+			continue
+		}
+		writer := &kafkaClient.Writer{
+			Addr:         client.Addr,
+			Topic:        spec.Name,
+			Transport:    client.Transport,
+			Balancer:     fixtureBalancer{},
+			BatchSize:    fixtureBatchSize,
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafkaClient.RequireAll,
+		}
+		for _, file := range spec.Fixtures {
+			if err := seedFixture(ctx, writer, file); err != nil {
+				_ = writer.Close()
+				return fmt.Errorf("unable to seed %s from %s: %w", spec.Name, file, err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedFixture(ctx context.Context, writer *kafkaClient.Writer, file string) error {
+	batch := make([]kafkaClient.Message, 0, fixtureBatchSize)
+	if err := readFixture(file, func(record KafkaRecord) error {
+		batch = append(batch, record.message())
+		if len(batch) < fixtureBatchSize {
+			return nil
+		}
+		err := writer.WriteMessages(ctx, batch...)
+		batch = batch[:0]
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return writer.WriteMessages(ctx, batch...)
+}
+
+// readFixture calls fn for every record of a JSONL file or a binary fixture file.
+func readFixture(file string, fn func(record KafkaRecord) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filepath.Ext(file) == ".jsonl" {
+		return readJSONLFixture(f, fn)
+	}
+	return readBinaryFixture(bufio.NewReader(f), fn)
+}
+
+func readJSONLFixture(r io.Reader, fn func(record KafkaRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record KafkaRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrInvalidFixture, line, err)
+		}
+		if record.Partition < 0 {
+			return fmt.Errorf("%w: line %d: negative partition %d", ErrInvalidFixture, line, record.Partition)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readBinaryFixture reads the format written by writeBinaryFixture.
+func readBinaryFixture(r *bufio.Reader, fn func(record KafkaRecord) error) error {
+	magic := make([]byte, len(fixtureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fixtureMagic {
+		return fmt.Errorf("%w: missing header, binary fixtures are written by CaptureFixture", ErrInvalidFixture)
+	}
+	for {
+		if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+			return nil
+		}
+		record, err := readBinaryRecord(r)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFixture, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+func readBinaryRecord(r *bufio.Reader) (KafkaRecord, error) {
+	var record KafkaRecord
+	partition, err := binary.ReadVarint(r)
+	if err != nil {
+		return record, err
+	}
+	if partition < 0 {
+		return record, fmt.Errorf("negative partition %d", partition)
+	}
+	record.Partition = int(partition)
+	if record.Offset, err = binary.ReadVarint(r); err != nil {
+		return record, err
+	}
+	timestamp, err := binary.ReadVarint(r)
+	if err != nil {
+		return record, err
+	}
+	record.Timestamp = time.UnixMilli(timestamp).UTC()
+	if record.Key, err = readFixtureBytes(r); err != nil {
+		return record, err
+	}
+	headers, err := binary.ReadUvarint(r)
+	if err != nil {
+		return record, err
+	}
+	for i := uint64(0); i < headers; i++ {
+		key, err := readFixtureBytes(r)
+		if err != nil {
+			return record, err
+		}
+		value, err := readFixtureBytes(r)
+		if err != nil {
+			return record, err
+		}
+		record.Headers = append(record.Headers, RecordHeader{Key: string(key), Value: value})
+	}
+	record.Value, err = readFixtureBytes(r)
+	return record, err
+}
+
+// readFixtureBytes reads a length prefixed byte slice, -1 stands for nil.
+func readFixtureBytes(r *bufio.Reader) (RecordBytes, error) {
+	length, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeFixture writes the records as JSONL if the file ends with .jsonl and in the binary format otherwise.
+func writeFixture(file string, records []KafkaRecord) error {
+	if filepath.Ext(file) == ".jsonl" {
+		return writeKafkaRecords(file, records)
+	}
+	return writeBinaryFixture(file, records)
+}
+
+// writeBinaryFixture writes the records after fixtureMagic as varint encoded partition, offset and timestamp
+// in milliseconds, followed by key, headers and value.
+func writeBinaryFixture(file string, records []KafkaRecord) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	buf := []byte(fixtureMagic)
+	for _, record := range records {
+		buf = binary.AppendVarint(buf, int64(record.Partition))
+		buf = binary.AppendVarint(buf, record.Offset)
+		buf = binary.AppendVarint(buf, record.Timestamp.UnixMilli())
+		buf = appendFixtureBytes(buf, record.Key)
+		buf = binary.AppendUvarint(buf, uint64(len(record.Headers)))
+		for _, header := range record.Headers {
+			buf = appendFixtureBytes(buf, []byte(header.Key))
+			buf = appendFixtureBytes(buf, header.Value)
+		}
+		buf = appendFixtureBytes(buf, record.Value)
+	}
+	return os.WriteFile(file, buf, 0644)
+}
+
+func appendFixtureBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return binary.AppendVarint(buf, -1)
+	}
+	buf = binary.AppendVarint(buf, int64(len(b)))
+	return append(buf, b...)
+}
+
+// CaptureKafkaFixture writes all records of a topic up to its current end to a fixture file, which can be used
+// in TopicSpec.Fixtures, and returns the number of records. The file is written as JSONL if it ends with .jsonl
+// and in a binary format otherwise. It works with any cluster, e.g. to capture a realistic backlog from staging.
+func CaptureKafkaFixture(ctx context.Context, dialer *kafkaClient.Dialer, brokers []string, topic string,
+	file string) (int, error) {
+	partitions, err := readPartitions(ctx, brokers[0], dialer, topic)
+	if err != nil {
+		return 0, err
+	}
+	bounds, err := partitionBounds(ctx, brokers[0], dialer, topic, partitions, ExpectOpts{})
+	if err != nil {
+		return 0, err
+	}
+	var records []KafkaRecord
+	for _, partition := range partitions {
+		bound := bounds[partition]
+		if bound.start >= bound.end {
+			continue
+		}
+		captured, err := capturePartition(ctx, dialer, brokers, topic, partition, bound)
+		if err != nil {
+			return 0, fmt.Errorf("unable to capture partition %d of %s: %w", partition, topic, err)
+		}
+		records = append(records, captured...)
+	}
+	if err := writeFixture(file, records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// capturePartition reads the records of a partition batch by batch until the connection offset reaches the end of
+// the bound. Unlike waiting for the record at end-1, this also terminates when the partition ends with a transaction
+// marker, which is skipped by the batch but never returned as message.
+func capturePartition(ctx context.Context, dialer *kafkaClient.Dialer, brokers []string, topic string, partition int,
+	bound offsetRange) ([]KafkaRecord, error) {
+	conn, err := dialer.DialLeader(ctx, "tcp", brokers[0], topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Seek(bound.start, kafkaClient.SeekAbsolute); err != nil {
+		return nil, err
+	}
+	var records []KafkaRecord
+	for {
+		offset, _ := conn.Offset()
+		if offset >= bound.end {
+			return records, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(10 * time.Second)
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		batch := conn.ReadBatch(1, 10e6)
+		for {
+			msg, err := batch.ReadMessage()
+			if err != nil || msg.Offset >= bound.end {
+				break
+			}
+			records = append(records, newKafkaRecord(msg))
+		}
+		if err := batch.Close(); err != nil {
+			return nil, err
+		}
+		if next, _ := conn.Offset(); next <= offset {
+			return nil, fmt.Errorf("no progress at offset %d of %d", offset, bound.end)
+		}
+	}
+}
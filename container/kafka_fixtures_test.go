@@ -0,0 +1,96 @@
+package container
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectFixture(t *testing.T, file string) []KafkaRecord {
+	t.Helper()
+	var records []KafkaRecord
+	require.NoError(t, readFixture(file, func(record KafkaRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	return records
+}
+
+func TestFixtureRoundTrip(t *testing.T) {
+	records := []KafkaRecord{
+		{
+			Partition: 2,
+			Offset:    10,
+			Key:       RecordBytes("1"),
+			Headers:   []RecordHeader{{Key: "type", Value: RecordBytes("order")}, {Key: "empty", Value: nil}},
+			Value:     RecordBytes(`{"status":"paid"}`),
+			Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			Offset:    11,
+			Value:     RecordBytes{0, 0, 0, 0, 1, 0xff},
+			Timestamp: time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		},
+		{Offset: 12, Key: RecordBytes("tombstone"), Timestamp: time.Date(2024, 5, 1, 12, 0, 2, 0, time.UTC)},
+	}
+	for _, name := range []string{"orders.jsonl", "orders.bin"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			require.NoError(t, writeFixture(file, records))
+			assert.Equal(t, records, collectFixture(t, file))
+		})
+	}
+}
+
+func TestReadJSONLFixture(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(`{"key": "1", "value": "{\"id\": 1}"}
+
+{"key": "2", "headers": [{"key": "type", "value": "order"}], "value": {"base64": "AAE="}}
+`), 0600))
+
+	records := collectFixture(t, file)
+	require.Len(t, records, 2)
+	assert.Equal(t, RecordBytes(`{"id": 1}`), records[0].Value)
+	assert.Equal(t, RecordBytes{0, 1}, records[1].Value)
+	assert.Equal(t, []RecordHeader{{Key: "type", Value: RecordBytes("order")}}, records[1].Headers)
+
+	require.NoError(t, os.WriteFile(file, []byte("{\"key\": \"1\"}\nnot json\n"), 0600))
+	err := readFixture(file, func(KafkaRecord) error { return nil })
+	assert.ErrorIs(t, err, ErrInvalidFixture)
+	assert.ErrorContains(t, err, "line 2")
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"partition": -1, "value": "x"}`), 0600))
+	assert.ErrorIs(t, readFixture(file, func(KafkaRecord) error { return nil }), ErrInvalidFixture)
+}
+
+func TestReadBinaryFixtureInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.bin")
+	require.NoError(t, os.WriteFile(file, []byte("raw value"), 0600))
+	assert.ErrorIs(t, readFixture(file, func(KafkaRecord) error { return nil }), ErrInvalidFixture)
+
+	require.NoError(t, os.WriteFile(file, []byte(fixtureMagic+"\x02\x14"), 0600))
+	assert.ErrorIs(t, readFixture(file, func(KafkaRecord) error { return nil }), ErrInvalidFixture)
+
+	require.NoError(t, os.WriteFile(file, append([]byte(fixtureMagic), binary.AppendVarint(nil, -1)...), 0600))
+	assert.ErrorIs(t, readFixture(file, func(KafkaRecord) error { return nil }), ErrInvalidFixture)
+}
+
+func TestFixtureBalancer(t *testing.T) {
+	partitions := []int{0, 1, 2}
+	assert.Equal(t, 1, fixtureBalancer{}.Balance(kafkaClient.Message{Partition: 4}, partitions...))
+	assert.Equal(t, 2, fixtureBalancer{}.Balance(kafkaClient.Message{Partition: -1}, partitions...))
+
+	assert.Equal(t, 2, fixtureBalancer{}.Balance(kafkaClient.Message{Partition: 2, Key: []byte("order-1")},
+		partitions...), "keyed records keep their partition")
+
+	keyed := kafkaClient.Message{Partition: 5, Key: []byte("order-1")}
+	assert.Equal(t, kafkaClient.Murmur2Balancer{}.Balance(keyed, partitions...),
+		fixtureBalancer{}.Balance(keyed, partitions...))
+}
@@ -0,0 +1,338 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+var ErrGroupNotEmpty = errors.New("consumer group has active members")
+
+// ConsumerGroup is the state of a consumer group with its committed offsets.
+type ConsumerGroup struct {
+	ID string
+	// State is e.g. "Stable", "PreparingRebalance", "Empty" or "Dead" if the group does not exist.
+	State   string
+	Members []GroupMember
+	// Partitions holds every partition the group committed an offset for.
+	Partitions []GroupPartition
+}
+
+// Lag returns the number of records the group did not consume yet over all partitions.
+func (g ConsumerGroup) Lag() int64 {
+	var lag int64
+	for _, partition := range g.Partitions {
+		lag += partition.Lag
+	}
+	return lag
+}
+
+type GroupMember struct {
+	ID       string
+	ClientID string
+	Host     string
+	// Assignments holds the assigned partitions by topic.
+	Assignments map[string][]int
+}
+
+type GroupPartition struct {
+	Topic     string
+	Partition int
+	// CommittedOffset is -1 if the group did not commit an offset for the partition.
+	CommittedOffset int64
+	EndOffset       int64
+	// Lag is the number of records after the committed offset, or all records if nothing is committed.
+	Lag int64
+}
+
+func (p GroupPartition) String() string {
+	return fmt.Sprintf("%s/%d: committed %d, end %d, lag %d", p.Topic, p.Partition, p.CommittedOffset, p.EndOffset, p.Lag)
+}
+
+// describeGroups describes the groups or all groups if none are given.
+func describeGroups(ctx context.Context, client *kafkaClient.Client, groups []string) ([]ConsumerGroup, error) {
+	if len(groups) == 0 {
+		listed, err := client.ListGroups(ctx, &kafkaClient.ListGroupsRequest{})
+		if err != nil {
+			return nil, err
+		}
+		if listed.Error != nil {
+			return nil, listed.Error
+		}
+		for _, group := range listed.Groups {
+			groups = append(groups, group.GroupID)
+		}
+		sort.Strings(groups)
+		if len(groups) == 0 {
+			return nil, nil
+		}
+	}
+	response, err := client.DescribeGroups(ctx, &kafkaClient.DescribeGroupsRequest{GroupIDs: groups})
+	if err != nil {
+		return nil, err
+	}
+	described := make([]ConsumerGroup, 0, len(response.Groups))
+	for _, group := range response.Groups {
+		if group.Error != nil {
+			return nil, fmt.Errorf("unable to describe group %s: %w", group.GroupID, group.Error)
+		}
+		partitions, err := groupPartitions(ctx, client, group.GroupID, nil)
+		if err != nil {
+			return nil, err
+		}
+		consumerGroup := ConsumerGroup{ID: group.GroupID, State: group.GroupState, Partitions: partitions}
+		for _, member := range group.Members {
+			assignments := map[string][]int{}
+			for _, topic := range member.MemberAssignments.Topics {
+				assignments[topic.Topic] = topic.Partitions
+			}
+			consumerGroup.Members = append(consumerGroup.Members, GroupMember{
+				ID:          member.MemberID,
+				ClientID:    member.ClientID,
+				Host:        member.ClientHost,
+				Assignments: assignments,
+			})
+		}
+		described = append(described, consumerGroup)
+	}
+	return described, nil
+}
+
+// groupPartitions returns the offsets and lag of the group for every partition of the topics,
+// or for the partitions the group committed offsets for if no topics are given.
+func groupPartitions(ctx context.Context, client *kafkaClient.Client, group string,
+	topics []string) ([]GroupPartition, error) {
+	request := &kafkaClient.OffsetFetchRequest{GroupID: group}
+	if len(topics) > 0 {
+		partitions, err := topicPartitionIDs(ctx, client, topics)
+		if err != nil {
+			return nil, err
+		}
+		request.Topics = partitions
+	}
+	fetched, err := client.OffsetFetch(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if fetched.Error != nil {
+		return nil, fmt.Errorf("unable to fetch offsets of group %s: %w", group, fetched.Error)
+	}
+
+	var partitions []GroupPartition
+	offsetRequest := &kafkaClient.ListOffsetsRequest{Topics: map[string][]kafkaClient.OffsetRequest{}}
+	for topic, fetchedPartitions := range fetched.Topics {
+		for _, partition := range fetchedPartitions {
+			if partition.Error != nil {
+				return nil, fmt.Errorf("unable to fetch offset of %s/%d for group %s: %w",
+					topic, partition.Partition, group, partition.Error)
+			}
+			if len(topics) == 0 && partition.CommittedOffset < 0 {
+				continue
+			}
+			partitions = append(partitions, GroupPartition{
+				Topic:           topic,
+				Partition:       partition.Partition,
+				CommittedOffset: partition.CommittedOffset,
+			})
+			offsetRequest.Topics[topic] = append(offsetRequest.Topics[topic],
+				kafkaClient.FirstOffsetOf(partition.Partition), kafkaClient.LastOffsetOf(partition.Partition))
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	offsets, err := client.ListOffsets(ctx, offsetRequest)
+	if err != nil {
+		return nil, err
+	}
+	bounds := map[string]map[int]kafkaClient.PartitionOffsets{}
+	for topic, topicOffsets := range offsets.Topics {
+		bounds[topic] = map[int]kafkaClient.PartitionOffsets{}
+		for _, partition := range topicOffsets {
+			if partition.Error != nil {
+				return nil, fmt.Errorf("unable to list offsets of %s/%d: %w", topic, partition.Partition, partition.Error)
+			}
+			bounds[topic][partition.Partition] = partition
+		}
+	}
+	for i := range partitions {
+		bound := bounds[partitions[i].Topic][partitions[i].Partition]
+		partitions[i].EndOffset = bound.LastOffset
+		consumed := partitions[i].CommittedOffset
+		if consumed < 0 {
+			consumed = bound.FirstOffset
+		}
+		partitions[i].Lag = max(bound.LastOffset-consumed, 0)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions, nil
+}
+
+// topicPartitionIDs returns the partition ids of the topics from the metadata.
+func topicPartitionIDs(ctx context.Context, client *kafkaClient.Client, topics []string) (map[string][]int, error) {
+	metadata, err := client.Metadata(ctx, &kafkaClient.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, err
+	}
+	partitions := map[string][]int{}
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return nil, fmt.Errorf("unable to describe topic %s: %w", topic.Name, topic.Error)
+		}
+		for _, partition := range topic.Partitions {
+			partitions[topic.Name] = append(partitions[topic.Name], partition.ID)
+		}
+	}
+	return partitions, nil
+}
+
+// waitForGroupCaughtUp polls the lag of the group on the topics until it is zero.
+func waitForGroupCaughtUp(ctx context.Context, client *kafkaClient.Client, group string, topics []string) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		partitions, err := groupPartitions(ctx, client, group, topics)
+		if err != nil {
+			return err
+		}
+		var behind []string
+		for _, partition := range partitions {
+			if partition.Lag > 0 {
+				behind = append(behind, partition.String())
+			}
+		}
+		if len(behind) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("group %s did not catch up: %s: %w", group, strings.Join(behind, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// requireEmptyGroup fails if the group has members, as the coordinator rejects offset changes of active groups.
+func requireEmptyGroup(ctx context.Context, client *kafkaClient.Client, group string) error {
+	response, err := client.DescribeGroups(ctx, &kafkaClient.DescribeGroupsRequest{GroupIDs: []string{group}})
+	if err != nil {
+		return err
+	}
+	for _, described := range response.Groups {
+		if described.Error != nil {
+			return fmt.Errorf("unable to describe group %s: %w", group, described.Error)
+		}
+		if len(described.Members) > 0 {
+			return fmt.Errorf("%w: %s has %d members", ErrGroupNotEmpty, group, len(described.Members))
+		}
+	}
+	return nil
+}
+
+// resetGroupOffsets commits the offset for every partition of the topic. kafka.FirstOffset and kafka.LastOffset
+// are resolved to the current first and last offset of each partition.
+func resetGroupOffsets(ctx context.Context, client *kafkaClient.Client, group string, topic string,
+	offset int64) error {
+	if err := requireEmptyGroup(ctx, client, group); err != nil {
+		return err
+	}
+	partitions, err := topicPartitionIDs(ctx, client, []string{topic})
+	if err != nil {
+		return err
+	}
+	commits := make([]kafkaClient.OffsetCommit, 0, len(partitions[topic]))
+	resolved := map[int]int64{}
+	if offset == kafkaClient.FirstOffset || offset == kafkaClient.LastOffset {
+		request := &kafkaClient.ListOffsetsRequest{Topics: map[string][]kafkaClient.OffsetRequest{}}
+		for _, partition := range partitions[topic] {
+			request.Topics[topic] = append(request.Topics[topic],
+				kafkaClient.OffsetRequest{Partition: partition, Timestamp: offset})
+		}
+		response, err := client.ListOffsets(ctx, request)
+		if err != nil {
+			return err
+		}
+		for _, partition := range response.Topics[topic] {
+			if partition.Error != nil {
+				return fmt.Errorf("unable to list offsets of %s/%d: %w", topic, partition.Partition, partition.Error)
+			}
+			resolved[partition.Partition] = partition.LastOffset
+			if offset == kafkaClient.FirstOffset {
+				resolved[partition.Partition] = partition.FirstOffset
+			}
+		}
+	}
+	for _, partition := range partitions[topic] {
+		committed := offset
+		if value, ok := resolved[partition]; ok {
+			committed = value
+		}
+		commits = append(commits, kafkaClient.OffsetCommit{Partition: partition, Offset: committed})
+	}
+	// Without generation and member the coordinator accepts commits for groups without active members.
+	response, err := client.OffsetCommit(ctx, &kafkaClient.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafkaClient.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, partition := range response.Topics[topic] {
+		if partition.Error != nil {
+			errs = append(errs, fmt.Errorf("unable to reset offset of %s/%d for group %s: %w",
+				topic, partition.Partition, group, partition.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteGroupOffsets deletes the committed offsets of the group for the topics, or the whole group if no topics
+// are given.
+func deleteGroupOffsets(ctx context.Context, client *kafkaClient.Client, group string, topics []string) error {
+	if err := requireEmptyGroup(ctx, client, group); err != nil {
+		return err
+	}
+	if len(topics) == 0 {
+		response, err := client.DeleteGroups(ctx, &kafkaClient.DeleteGroupsRequest{GroupIDs: []string{group}})
+		if err != nil {
+			return err
+		}
+		if err := response.Errors[group]; err != nil {
+			return fmt.Errorf("unable to delete group %s: %w", group, err)
+		}
+		return nil
+	}
+	partitions, err := topicPartitionIDs(ctx, client, topics)
+	if err != nil {
+		return err
+	}
+	response, err := client.OffsetDelete(ctx, &kafkaClient.OffsetDeleteRequest{GroupID: group, Topics: partitions})
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("unable to delete offsets of group %s: %w", group, response.Error)
+	}
+	var errs []error
+	for topic, deleted := range response.Topics {
+		for _, partition := range deleted {
+			if partition.Error != nil {
+				errs = append(errs, fmt.Errorf("unable to delete offset of %s/%d for group %s: %w",
+					topic, partition.Partition, group, partition.Error))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,16 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerGroupLag(t *testing.T) {
+	group := ConsumerGroup{ID: "orders", Partitions: []GroupPartition{
+		{Topic: "orders", Partition: 0, CommittedOffset: 3, EndOffset: 5, Lag: 2},
+		{Topic: "orders", Partition: 1, CommittedOffset: -1, EndOffset: 4, Lag: 4},
+	}}
+	assert.Equal(t, int64(6), group.Lag())
+	assert.Equal(t, "orders/1: committed -1, end 4, lag 4", group.Partitions[1].String())
+}
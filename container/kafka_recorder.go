@@ -0,0 +1,447 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+// recorderDiscoveryInterval is how often the recorder looks for new topics and partitions.
+const recorderDiscoveryInterval = 250 * time.Millisecond
+
+var artifactName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// KafkaRecord is a message as written to JSONL files, one record per line.
+type KafkaRecord struct {
+	Topic     string         `json:"topic"`
+	Partition int            `json:"partition"`
+	Offset    int64          `json:"offset"`
+	Key       RecordBytes    `json:"key,omitempty"`
+	Headers   []RecordHeader `json:"headers,omitempty"`
+	Value     RecordBytes    `json:"value"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+type RecordHeader struct {
+	Key   string      `json:"key"`
+	Value RecordBytes `json:"value"`
+}
+
+// RecordBytes is written as JSON string if it is valid UTF-8 and as {"base64": "..."} otherwise.
+type RecordBytes []byte
+
+func (b RecordBytes) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *RecordBytes) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*b = nil
+	case string:
+		*b = RecordBytes(value)
+	case map[string]any:
+		encoded, ok := value["base64"].(string)
+		if !ok || len(value) != 1 {
+			return fmt.Errorf("record bytes have to be a string or {\"base64\": \"...\"}, got %s", data)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		*b = decoded
+	default:
+		return fmt.Errorf("record bytes have to be a string or {\"base64\": \"...\"}, got %s", data)
+	}
+	return nil
+}
+
+func newKafkaRecord(msg kafkaClient.Message) KafkaRecord {
+	record := KafkaRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Timestamp: msg.Time,
+	}
+	for _, header := range msg.Headers {
+		record.Headers = append(record.Headers, RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	return record
+}
+
+// writeKafkaRecords writes the records to a JSONL file.
+func writeKafkaRecords(path string, records []KafkaRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// TopicRecorderOpts configures a TopicRecorder.
+type TopicRecorderOpts struct {
+	// Topics is a regular expression the recorded topics have to match. Defaults to all but internal topics.
+	Topics string
+	// Dir the transcript is written to if the test fails. Defaults to testsetup-artifacts in the temp directory.
+	Dir string
+	// User to consume as if SASL is enabled. Defaults to the admin user.
+	User string
+}
+
+// TopicRecorder records every message produced to the matching topics while a test runs. Topics existing when
+// the recorder is created are recorded from their end, topics created later from their beginning.
+// If the test fails, the records are written to <Dir>/<test name>.kafka.jsonl. The recorder consumes
+// everything up to the current end once the container stops, so a deferred TestSetup.Stop loses nothing.
+type TopicRecorder struct {
+	t       testing.TB
+	brokers []string
+	dialer  *kafkaClient.Dialer
+	topics  *regexp.Regexp
+	dir     string
+	// ctx is done once the recorder finished.
+	ctx      context.Context
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+	finished sync.Once
+	// discovering prevents that the background discovery and sync start a partition twice.
+	discovering sync.Mutex
+
+	mu      sync.Mutex
+	updated chan struct{}
+	records []KafkaRecord
+	// positions holds the next offset to record by topic and partition.
+	positions map[string]map[int]int64
+	err       error
+}
+
+// topicRecorders holds the running recorders of a container, which are finished before the container stops.
+type topicRecorders struct {
+	mu        sync.Mutex
+	recorders map[*TopicRecorder]bool
+}
+
+func (rs *topicRecorders) add(r *TopicRecorder) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.recorders == nil {
+		rs.recorders = map[*TopicRecorder]bool{}
+	}
+	rs.recorders[r] = true
+}
+
+func (rs *topicRecorders) remove(r *TopicRecorder) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.recorders, r)
+}
+
+// finish consumes the remaining records of all recorders while the brokers are still running.
+func (rs *topicRecorders) finish() {
+	rs.mu.Lock()
+	recorders := rs.recorders
+	rs.recorders = nil
+	rs.mu.Unlock()
+	for r := range recorders {
+		r.finish()
+	}
+}
+
+// recordTopicsAs dials as the user of the opts or the admin user and starts recording.
+// The recorder is finished by the container on stop at the latest.
+func recordTopicsAs(t testing.TB, security *kafkaSecurity, brokers []string, opts TopicRecorderOpts,
+	recorders *topicRecorders) *TopicRecorder {
+	t.Helper()
+	user := opts.User
+	if user == "" {
+		user = security.adminUser()
+	}
+	dialer, err := security.dialer(user)
+	if err != nil {
+		t.Fatalf("unable to record topics: %v", err)
+	}
+	r := newTopicRecorder(t, brokers, dialer, opts)
+	recorders.add(r)
+	t.Cleanup(func() {
+		recorders.remove(r)
+	})
+	return r
+}
+
+func newTopicRecorder(t testing.TB, brokers []string, dialer *kafkaClient.Dialer, opts TopicRecorderOpts) *TopicRecorder {
+	t.Helper()
+	topics, err := regexp.Compile(opts.Topics)
+	if err != nil {
+		t.Fatalf("invalid topic pattern: %v", err)
+	}
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(os.TempDir(), "testsetup-artifacts")
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	r := &TopicRecorder{
+		t:         t,
+		brokers:   brokers,
+		dialer:    dialer,
+		topics:    topics,
+		dir:       opts.Dir,
+		ctx:       ctx,
+		stop:      stop,
+		updated:   make(chan struct{}),
+		positions: map[string]map[int]int64{},
+	}
+	if err := r.discover(ctx, true); err != nil {
+		stop()
+		t.Fatalf("unable to record topics: %v", err)
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(recorderDiscoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Partitions of new topics might not have a leader yet, they are picked up by the next run.
+				_ = r.discover(ctx, false)
+			}
+		}
+	}()
+	t.Cleanup(r.cleanup)
+	return r
+}
+
+// cleanup finishes the recorder and writes the transcript if the test failed.
+func (r *TopicRecorder) cleanup() {
+	r.finish()
+	if !r.t.Failed() {
+		return
+	}
+	path := filepath.Join(r.dir, artifactName.ReplaceAllString(r.t.Name(), "_")+".kafka.jsonl")
+	if err := writeKafkaRecords(path, r.snapshot()); err != nil {
+		r.t.Errorf("unable to write kafka transcript: %v", err)
+	} else {
+		r.t.Logf("kafka transcript written to %s", path)
+	}
+}
+
+// finish consumes the recorded partitions up to their current end and stops recording.
+func (r *TopicRecorder) finish() {
+	r.finished.Do(func() {
+		r.flush()
+		r.stop()
+		r.wg.Wait()
+	})
+}
+
+// discover starts recording partitions that are not recorded yet. On start the existing partitions
+// are recorded from their end.
+func (r *TopicRecorder) discover(ctx context.Context, start bool) error {
+	r.discovering.Lock()
+	defer r.discovering.Unlock()
+	conn, err := r.dialer.DialContext(ctx, "tcp", r.brokers[0])
+	if err != nil {
+		return err
+	}
+	partitions, err := conn.ReadPartitions()
+	_ = conn.Close()
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		if strings.HasPrefix(partition.Topic, "_") || !r.topics.MatchString(partition.Topic) {
+			continue
+		}
+		r.mu.Lock()
+		_, known := r.positions[partition.Topic][partition.ID]
+		r.mu.Unlock()
+		if known {
+			continue
+		}
+		offset, err := r.startOffset(ctx, partition.Topic, partition.ID, start)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		if r.positions[partition.Topic] == nil {
+			r.positions[partition.Topic] = map[int]int64{}
+		}
+		r.positions[partition.Topic][partition.ID] = offset
+		r.mu.Unlock()
+		r.record(partition.Topic, partition.ID, offset)
+	}
+	return nil
+}
+
+func (r *TopicRecorder) startOffset(ctx context.Context, topic string, partition int, end bool) (int64, error) {
+	conn, err := r.dialer.DialLeader(ctx, "tcp", r.brokers[0], topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if end {
+		return conn.ReadLastOffset()
+	}
+	return conn.ReadFirstOffset()
+}
+
+// record reads the partition from the offset until the recorder stops. The position follows the fetch offset,
+// which moves past transaction markers, so a partition ending with one is still caught up.
+func (r *TopicRecorder) record(topic string, partition int, offset int64) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		err := readBatches(r.ctx, r.dialer, r.brokers[0], topic, partition, offset, func(batch partitionBatch) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, msg := range batch.messages {
+				r.records = append(r.records, newKafkaRecord(msg))
+			}
+			r.positions[topic][partition] = batch.next
+			r.notify()
+			return nil
+		})
+		if err != nil && r.ctx.Err() == nil {
+			r.fail(err)
+		}
+	}()
+}
+
+func (r *TopicRecorder) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// notify wakes up everyone waiting for new records. It has to be called with the lock held.
+func (r *TopicRecorder) notify() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// Records waits until every recorded partition is consumed up to its current end and returns the records.
+func (r *TopicRecorder) Records() []KafkaRecord {
+	r.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.sync(ctx); err != nil {
+		r.t.Fatalf("unable to collect records: %v", err)
+	}
+	return r.snapshot()
+}
+
+// flush consumes the remaining records for a few seconds at most, as the broker might already be gone.
+func (r *TopicRecorder) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = r.sync(ctx)
+}
+
+// snapshot returns the records ordered by their timestamp.
+func (r *TopicRecorder) snapshot() []KafkaRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make([]KafkaRecord, len(r.records))
+	copy(records, r.records)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records
+}
+
+func (r *TopicRecorder) sync(ctx context.Context) error {
+	if err := r.discover(ctx, false); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	ends := map[string]map[int]int64{}
+	for topic, partitions := range r.positions {
+		ends[topic] = map[int]int64{}
+		for partition := range partitions {
+			ends[topic][partition] = 0
+		}
+	}
+	r.mu.Unlock()
+	for topic, partitions := range ends {
+		for partition := range partitions {
+			conn, err := r.dialer.DialLeader(ctx, "tcp", r.brokers[0], topic, partition)
+			if err != nil {
+				return err
+			}
+			end, err := conn.ReadLastOffset()
+			_ = conn.Close()
+			if err != nil {
+				return err
+			}
+			partitions[partition] = end
+		}
+	}
+	for {
+		r.mu.Lock()
+		err := r.err
+		caughtUp := true
+		for topic, partitions := range ends {
+			for partition, end := range partitions {
+				if r.positions[topic][partition] < end {
+					caughtUp = false
+				}
+			}
+		}
+		updated := r.updated
+		r.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	kafkaClient "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes RecordBytes
+		json  string
+	}{
+		{name: "nil", bytes: nil, json: `null`},
+		{name: "text", bytes: RecordBytes(`{"id":1}`), json: `"{\"id\":1}"`},
+		{name: "binary", bytes: RecordBytes{0, 0, 0, 0, 1, 0xff}, json: `{"base64":"AAAAAAH/"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.bytes)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.json, string(data))
+
+			var decoded RecordBytes
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, test.bytes, decoded)
+		})
+	}
+
+	var decoded RecordBytes
+	assert.Error(t, json.Unmarshal([]byte(`{"hex": "ff"}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`42`), &decoded))
+}
+
+func TestWriteKafkaRecords(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := newKafkaRecord(kafkaClient.Message{
+		Topic:     "orders",
+		Partition: 1,
+		Offset:    42,
+		Key:       []byte("1"),
+		Value:     []byte(`{"status":"paid"}`),
+		Headers:   []kafkaClient.Header{{Key: "type", Value: []byte("payment")}},
+		Time:      timestamp,
+	})
+	path := filepath.Join(t.TempDir(), "nested", "transcript.kafka.jsonl")
+	require.NoError(t, writeKafkaRecords(path, []KafkaRecord{record, record}))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"topic": "orders", "partition": 1, "offset": 42, "key": "1",
+		"headers": [{"key": "type", "value": "payment"}], "value": "{\"status\":\"paid\"}",
+		"timestamp": "2024-05-01T12:00:00Z"}`, lines[0])
+}
+
+// failedTB is a test that already failed, it collects the reported errors.
+type failedTB struct {
+	testing.TB
+	name   string
+	errors []string
+}
+
+func (f *failedTB) Helper()             {}
+func (f *failedTB) Failed() bool        { return true }
+func (f *failedTB) Name() string        { return f.name }
+func (f *failedTB) Logf(string, ...any) {}
+func (f *failedTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestTopicRecorderTranscriptOnFailure(t *testing.T) {
+	tb := &failedTB{name: "TestOrders/paid order"}
+	dir := t.TempDir()
+	stopped := false
+	record := newKafkaRecord(kafkaClient.Message{Topic: "orders", Offset: 3, Value: []byte("paid")})
+	r := &TopicRecorder{
+		t: tb,
+		// The broker is gone already, the recorded messages are written anyway.
+		brokers:   []string{"127.0.0.1:1"},
+		dialer:    &kafkaClient.Dialer{Timeout: time.Second},
+		topics:    regexp.MustCompile(""),
+		dir:       dir,
+		stop:      func() { stopped = true },
+		updated:   make(chan struct{}),
+		records:   []KafkaRecord{record},
+		positions: map[string]map[int]int64{},
+	}
+	recorders := &topicRecorders{}
+	recorders.add(r)
+	recorders.finish()
+	assert.True(t, stopped, "the container stop finishes the recorder")
+
+	r.cleanup()
+	assert.Empty(t, tb.errors)
+	content, err := os.ReadFile(filepath.Join(dir, "TestOrders_paid_order.kafka.jsonl"))
+	require.NoError(t, err)
+	var written KafkaRecord
+	require.NoError(t, json.Unmarshal(content, &written))
+	assert.Equal(t, record, written)
+}
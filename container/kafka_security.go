@@ -0,0 +1,315 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/google/uuid"
+	"github.com/ory/dockertest"
+	kafkaClient "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaSASLMechanism is a SASL mechanism supported on the external listener.
+type KafkaSASLMechanism string
+
+const (
+	KafkaSASLPlain       KafkaSASLMechanism = "PLAIN"
+	KafkaSASLScramSHA256 KafkaSASLMechanism = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 KafkaSASLMechanism = "SCRAM-SHA-512"
+)
+
+// kafkaTLSDir is the directory inside the container the TLS material is written to.
+const kafkaTLSDir = "/tmp/kafka-tls"
+
+var (
+	ErrUnknownKafkaUser     = errors.New("unknown kafka user")
+	ErrInvalidKafkaSecurity = errors.New("invalid kafka security options")
+)
+
+// KafkaSecurityOpts secures the external listener. The listeners inside the docker network stay plaintext.
+type KafkaSecurityOpts struct {
+	// SASL enables authentication with the given mechanism. Empty disables SASL.
+	SASL KafkaSASLMechanism
+	// TLS encrypts the external listener with a server certificate issued by a throwaway CA.
+	TLS bool
+	// Users maps user names to passwords. SCRAM passwords must not contain commas or square brackets.
+	// With SASL enabled and no users, the user "admin" with a random password is created.
+	Users map[string]string
+	// ACLs enables the authorizer, so requests of users are denied unless granted with GrantACL. Requires SASL,
+	// as unauthenticated clients would be anonymous like the brokers, which are allowed everything.
+	// Connections on the listeners inside the docker network are anonymous and allowed everything.
+	ACLs bool
+	// SuperUsers are allowed everything. The first one is used by the container itself, e.g. to create topics.
+	// If empty and ACLs are enabled, the first user by name becomes super user.
+	SuperUsers []string
+}
+
+// kafkaSecurity holds the security settings and the TLS material shared by all brokers of a cluster.
+type kafkaSecurity struct {
+	opts KafkaSecurityOpts
+	// hosts the server certificate is issued for, the first one is the external host.
+	hosts []string
+	certs *Certificates
+
+	// admin is shared by all admin clients of the container, so their connections are reused and closed on stop.
+	adminMu sync.Mutex
+	admin   *kafkaClient.Transport
+}
+
+func newKafkaSecurity(opts *KafkaSecurityOpts, hosts ...string) *kafkaSecurity {
+	s := &kafkaSecurity{hosts: append(hosts, "localhost", "127.0.0.1")}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.SASL != "" && len(s.opts.Users) == 0 {
+		s.opts.Users = map[string]string{"admin": uuid.New().String()}
+	}
+	return s
+}
+
+// protocol returns the security protocol of the external listener.
+func (s *kafkaSecurity) protocol() string {
+	switch {
+	case s.opts.SASL != "" && s.opts.TLS:
+		return "SASL_SSL"
+	case s.opts.SASL != "":
+		return "SASL_PLAINTEXT"
+	case s.opts.TLS:
+		return "SSL"
+	}
+	return "PLAINTEXT"
+}
+
+func (s *kafkaSecurity) scram() bool {
+	return s.opts.SASL == KafkaSASLScramSHA256 || s.opts.SASL == KafkaSASLScramSHA512
+}
+
+// users returns the user names sorted.
+func (s *kafkaSecurity) users() []string {
+	users := make([]string, 0, len(s.opts.Users))
+	for user := range s.opts.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// adminUser returns the user the container uses to talk to the brokers.
+func (s *kafkaSecurity) adminUser() string {
+	if len(s.opts.SuperUsers) > 0 {
+		return s.opts.SuperUsers[0]
+	}
+	if users := s.users(); len(users) > 0 {
+		return users[0]
+	}
+	return ""
+}
+
+// validate rejects options that would start a broker which does not enforce what was asked for.
+func (s *kafkaSecurity) validate() error {
+	if s.opts.ACLs && s.opts.SASL == "" {
+		return fmt.Errorf("%w: ACLs require SASL, otherwise every external client is anonymous", ErrInvalidKafkaSecurity)
+	}
+	return nil
+}
+
+// superUsers returns the principals allowed everything. The listeners inside the docker network are plaintext,
+// so brokers talk to each other as ANONYMOUS. Only with SASL on the external listener no external client is.
+func (s *kafkaSecurity) superUsers() string {
+	var principals []string
+	if s.opts.SASL != "" {
+		principals = append(principals, "User:ANONYMOUS")
+	}
+	users := s.opts.SuperUsers
+	if len(users) == 0 {
+		users = []string{s.adminUser()}
+	}
+	for _, user := range users {
+		principals = append(principals, "User:"+user)
+	}
+	return strings.Join(principals, ";")
+}
+
+// brokerEnv returns the broker settings of the external listener named EXTERNAL and the authorizer.
+// Dashes in property names are written as triple underscores, as the image expects.
+func (s *kafkaSecurity) brokerEnv(kraft bool) map[string]string {
+	env := map[string]string{}
+	if s.opts.ACLs {
+		env["KAFKA_AUTHORIZER_CLASS_NAME"] = "kafka.security.authorizer.AclAuthorizer"
+		if kraft {
+			env["KAFKA_AUTHORIZER_CLASS_NAME"] = "org.apache.kafka.metadata.authorizer.StandardAuthorizer"
+		}
+		env["KAFKA_SUPER_USERS"] = s.superUsers()
+	}
+	if s.opts.SASL != "" {
+		mechanism := string(s.opts.SASL)
+		env["KAFKA_SASL_ENABLED_MECHANISMS"] = mechanism
+		env["KAFKA_LISTENER_NAME_EXTERNAL_SASL_ENABLED_MECHANISMS"] = mechanism
+		jaasConfig := "org.apache.kafka.common.security.scram.ScramLoginModule required;"
+		if s.opts.SASL == KafkaSASLPlain {
+			jaasConfig = "org.apache.kafka.common.security.plain.PlainLoginModule required"
+			for _, user := range s.users() {
+				jaasConfig += " user_" + user + "=" + jaasQuote(s.opts.Users[user])
+			}
+			jaasConfig += ";"
+		}
+		env["KAFKA_LISTENER_NAME_EXTERNAL_"+strings.ReplaceAll(mechanism, "-", "___")+"_SASL_JAAS_CONFIG"] = jaasConfig
+	}
+	if s.opts.TLS {
+		env["KAFKA_LISTENER_NAME_EXTERNAL_SSL_KEYSTORE_TYPE"] = "PEM"
+		env["KAFKA_LISTENER_NAME_EXTERNAL_SSL_KEYSTORE_LOCATION"] = kafkaTLSDir + "/server.pem"
+	}
+	return env
+}
+
+func jaasQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// enableTLS generates the certificates once for all brokers.
+func (s *kafkaSecurity) enableTLS() error {
+	if !s.opts.TLS || s.certs != nil {
+		return nil
+	}
+	certs, err := newCertificates("kafka-client", s.hosts...)
+	if err != nil {
+		return err
+	}
+	s.certs = certs
+	return nil
+}
+
+// createScramUsers stores the SCRAM credentials of all users through the internal listener of the broker.
+func (s *kafkaSecurity) createScramUsers(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource,
+	internalPort string) error {
+	if !s.scram() {
+		return nil
+	}
+	for _, user := range s.users() {
+		if strings.ContainsAny(s.opts.Users[user], ",[]") {
+			return fmt.Errorf("SCRAM password of %s must not contain commas or square brackets", user)
+		}
+	}
+	for _, user := range s.users() {
+		// The first attempt fails if the broker is not ready yet.
+		if err := pool.Retry(func() error {
+			return testsetup.ExecInContainer(ctx, pool, resource, testsetup.ExecOpts{
+				Cmd: []string{"kafka-configs", "--bootstrap-server", "localhost:" + internalPort,
+					"--alter", "--entity-type", "users", "--entity-name", user,
+					"--add-config", string(s.opts.SASL) + "=[password=" + s.opts.Users[user] + "]"},
+			})
+		}); err != nil {
+			return fmt.Errorf("unable to create user %s: %w", user, err)
+		}
+	}
+	return nil
+}
+
+func (s *kafkaSecurity) mechanism(user string) (sasl.Mechanism, error) {
+	if s.opts.SASL == "" {
+		return nil, nil
+	}
+	password, ok := s.opts.Users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKafkaUser, user)
+	}
+	switch s.opts.SASL {
+	case KafkaSASLPlain:
+		return plain.Mechanism{Username: user, Password: password}, nil
+	case KafkaSASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, user, password)
+	case KafkaSASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, user, password)
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism %s", s.opts.SASL)
+}
+
+func (s *kafkaSecurity) dialer(user string) (*kafkaClient.Dialer, error) {
+	mechanism, err := s.mechanism(user)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &kafkaClient.Dialer{Timeout: 10 * time.Second, DualStack: true, SASLMechanism: mechanism}
+	if s.certs != nil {
+		dialer.TLS, err = s.certs.ClientTLSConfig(s.hosts[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dialer, nil
+}
+
+func (s *kafkaSecurity) transport(user string) (*kafkaClient.Transport, error) {
+	mechanism, err := s.mechanism(user)
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafkaClient.Transport{SASL: mechanism}
+	if s.certs != nil {
+		transport.TLS, err = s.certs.ClientTLSConfig(s.hosts[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return transport, nil
+}
+
+// adminTransport returns the transport of the admin user, which is created once.
+func (s *kafkaSecurity) adminTransport() (*kafkaClient.Transport, error) {
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	if s.admin == nil {
+		transport, err := s.transport(s.adminUser())
+		if err != nil {
+			return nil, err
+		}
+		s.admin = transport
+	}
+	return s.admin, nil
+}
+
+// closeAdminTransport closes the connections of the admin transport.
+func (s *kafkaSecurity) closeAdminTransport() {
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	if s.admin != nil {
+		s.admin.CloseIdleConnections()
+	}
+}
+
+// clientConfig returns the librdkafka properties to connect as user.
+func (s *kafkaSecurity) clientConfig(brokers []string, user string) (map[string]string, error) {
+	config := map[string]string{
+		"bootstrap.servers": strings.Join(brokers, ","),
+		"security.protocol": strings.ToLower(s.protocol()),
+	}
+	if s.opts.SASL != "" {
+		password, ok := s.opts.Users[user]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKafkaUser, user)
+		}
+		config["sasl.mechanisms"] = string(s.opts.SASL)
+		config["sasl.username"] = user
+		config["sasl.password"] = password
+	}
+	if s.certs != nil {
+		config["ssl.ca.location"] = s.certs.CACertFile()
+	}
+	return config, nil
+}
+
+// setup returns the shell step writing the server key and certificate into the container.
+// The variable must not start with KAFKA_, as the image turns those into broker properties.
+func (s *kafkaSecurity) setup(env map[string]string) string {
+	env["TESTSETUP_KAFKA_SERVER_PEM"] = string(s.certs.ServerKey) + string(s.certs.ServerCert)
+	return "mkdir -p " + kafkaTLSDir + ` && printf '%s' "$TESTSETUP_KAFKA_SERVER_PEM" > ` + kafkaTLSDir + "/server.pem"
+}
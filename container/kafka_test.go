@@ -0,0 +1,188 @@
+package container
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	kafkaClient "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKafkaClusterID(t *testing.T) {
+	id := newKafkaClusterID()
+	assert.Len(t, id, 22)
+	decoded, err := base64.RawURLEncoding.DecodeString(id)
+	require.NoError(t, err)
+	assert.Len(t, decoded, 16)
+	assert.NotEqual(t, id, newKafkaClusterID())
+}
+
+func TestTopicSpecs(t *testing.T) {
+	specs := topicSpecs(3, []TopicSpec{{
+		Name:       "compacted",
+		Partitions: 6,
+		Configs:    map[string]string{"cleanup.policy": "compact"},
+	}}, "plain")
+	assert.Equal(t, []TopicSpec{
+		{Name: "compacted", Partitions: 6, ReplicationFactor: 3, Configs: map[string]string{"cleanup.policy": "compact"}},
+		{Name: "plain", Partitions: 1, ReplicationFactor: 3},
+	}, specs)
+}
+
+func TestKafkaSecurityBrokerEnv(t *testing.T) {
+	plain := newKafkaSecurity(&KafkaSecurityOpts{
+		SASL:  KafkaSASLPlain,
+		Users: map[string]string{"bob": `se"cret`, "alice": "pw"},
+	}, "localhost")
+	assert.Equal(t, "SASL_PLAINTEXT", plain.protocol())
+	assert.Equal(t, map[string]string{
+		"KAFKA_SASL_ENABLED_MECHANISMS":                        "PLAIN",
+		"KAFKA_LISTENER_NAME_EXTERNAL_SASL_ENABLED_MECHANISMS": "PLAIN",
+		"KAFKA_LISTENER_NAME_EXTERNAL_PLAIN_SASL_JAAS_CONFIG": "org.apache.kafka.common.security.plain.PlainLoginModule required" +
+			` user_alice="pw" user_bob="se\"cret";`,
+	}, plain.brokerEnv(false))
+
+	scram := newKafkaSecurity(&KafkaSecurityOpts{SASL: KafkaSASLScramSHA512, TLS: true}, "localhost")
+	assert.Equal(t, "SASL_SSL", scram.protocol())
+	assert.Equal(t, []string{"admin"}, scram.users())
+	env := scram.brokerEnv(true)
+	assert.Equal(t, "org.apache.kafka.common.security.scram.ScramLoginModule required;",
+		env["KAFKA_LISTENER_NAME_EXTERNAL_SCRAM___SHA___512_SASL_JAAS_CONFIG"])
+	assert.Equal(t, "PEM", env["KAFKA_LISTENER_NAME_EXTERNAL_SSL_KEYSTORE_TYPE"])
+
+	assert.Equal(t, "PLAINTEXT", newKafkaSecurity(nil, "localhost").protocol())
+	assert.Empty(t, newKafkaSecurity(nil, "localhost").brokerEnv(false))
+}
+
+func TestKafkaSecurityClientConfig(t *testing.T) {
+	security := newKafkaSecurity(&KafkaSecurityOpts{
+		SASL:  KafkaSASLScramSHA256,
+		Users: map[string]string{"alice": "pw"},
+	}, "localhost")
+	config, err := security.clientConfig([]string{"localhost:9092"}, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"bootstrap.servers": "localhost:9092",
+		"security.protocol": "sasl_plaintext",
+		"sasl.mechanisms":   "SCRAM-SHA-256",
+		"sasl.username":     "alice",
+		"sasl.password":     "pw",
+	}, config)
+
+	_, err = security.clientConfig([]string{"localhost:9092"}, "mallory")
+	assert.ErrorIs(t, err, ErrUnknownKafkaUser)
+	_, err = security.dialer("mallory")
+	assert.ErrorIs(t, err, ErrUnknownKafkaUser)
+}
+
+func TestKafkaSecurityACLs(t *testing.T) {
+	security := newKafkaSecurity(&KafkaSecurityOpts{
+		SASL:  KafkaSASLPlain,
+		Users: map[string]string{"service": "pw", "admin": "pw"},
+		ACLs:  true,
+	}, "localhost")
+	assert.Equal(t, "admin", security.adminUser())
+	assert.Equal(t, "User:ANONYMOUS;User:admin", security.brokerEnv(false)["KAFKA_SUPER_USERS"])
+	assert.Equal(t, "kafka.security.authorizer.AclAuthorizer", security.brokerEnv(false)["KAFKA_AUTHORIZER_CLASS_NAME"])
+	assert.Equal(t, "org.apache.kafka.metadata.authorizer.StandardAuthorizer",
+		security.brokerEnv(true)["KAFKA_AUTHORIZER_CLASS_NAME"])
+
+	security.opts.SuperUsers = []string{"service"}
+	assert.Equal(t, "service", security.adminUser())
+	assert.Equal(t, "User:ANONYMOUS;User:service", security.superUsers())
+}
+
+func TestKafkaSecurityACLsRequireSASL(t *testing.T) {
+	assert.NoError(t, newKafkaSecurity(nil, "localhost").validate())
+	assert.NoError(t, newKafkaSecurity(&KafkaSecurityOpts{SASL: KafkaSASLPlain, ACLs: true}, "localhost").validate())
+
+	k := WithKafka(KafkaOpts{ContainerName: "kafka", Security: &KafkaSecurityOpts{TLS: true, ACLs: true}})
+	assert.ErrorIs(t, k.Start(docker.AuthConfiguration{}, nil), ErrInvalidKafkaSecurity)
+	cluster := WithKafkaCluster(3, KafkaOpts{ContainerName: "kafka", Security: &KafkaSecurityOpts{ACLs: true}})
+	assert.ErrorIs(t, cluster.Start(docker.AuthConfiguration{}, nil), ErrInvalidKafkaSecurity)
+}
+
+func TestKafkaSecurityAdminTransport(t *testing.T) {
+	security := newKafkaSecurity(&KafkaSecurityOpts{SASL: KafkaSASLPlain}, "localhost")
+	transport, err := security.adminTransport()
+	require.NoError(t, err)
+	again, err := security.adminTransport()
+	require.NoError(t, err)
+	assert.Same(t, transport, again, "admin clients share one transport")
+	security.closeAdminTransport()
+}
+
+func TestKafkaACLEntries(t *testing.T) {
+	acl := TopicACL("service", "orders.", kafkaClient.ACLOperationTypeRead, kafkaClient.ACLOperationTypeDescribe)
+	acl.Prefixed = true
+	entries := aclEntries([]KafkaACL{acl, GroupACL("service", "orders-consumer", kafkaClient.ACLOperationTypeRead)})
+	require.Len(t, entries, 3)
+	assert.Equal(t, kafkaClient.ACLEntry{
+		ResourceType:        kafkaClient.ResourceTypeTopic,
+		ResourceName:        "orders.",
+		ResourcePatternType: kafkaClient.PatternTypePrefixed,
+		Principal:           "User:service",
+		Host:                "*",
+		Operation:           kafkaClient.ACLOperationTypeRead,
+		PermissionType:      kafkaClient.ACLPermissionTypeAllow,
+	}, entries[0])
+	assert.Equal(t, kafkaClient.ACLOperationTypeDescribe, entries[1].Operation)
+	assert.Equal(t, kafkaClient.ResourceTypeGroup, entries[2].ResourceType)
+	assert.Equal(t, kafkaClient.PatternTypeLiteral, entries[2].ResourcePatternType)
+}
+
+func TestWithKafkaCluster(t *testing.T) {
+	cluster := WithKafkaCluster(3, KafkaOpts{
+		ContainerName:    "kafka",
+		ExternalHostName: "localhost",
+		ExternalPort:     "9092",
+		KRaft:            true,
+	}, "orders").(*kafkaCluster)
+	assert.Equal(t, 3, cluster.Size())
+	assert.Equal(t, []string{"localhost:9092", "localhost:9093", "localhost:9094"}, cluster.Brokers())
+	assert.Equal(t, "kafka-2", cluster.brokers[1].Opts.ContainerName)
+	assert.Equal(t, "1@kafka-1:"+kafkaControllerPort+",2@kafka-2:"+kafkaControllerPort+",3@kafka-3:"+kafkaControllerPort,
+		cluster.brokers[1].Opts.Env["KAFKA_CONTROLLER_QUORUM_VOTERS"])
+	assert.Equal(t, []TopicSpec{{Name: "orders", Partitions: 1, ReplicationFactor: 3}}, cluster.topics)
+
+	for _, n := range []int{0, -1} {
+		empty := WithKafkaCluster(n, KafkaOpts{ContainerName: "kafka", ExternalPort: "9092"})
+		assert.ErrorIs(t, empty.Start(docker.AuthConfiguration{}, nil), ErrInvalidSize)
+		assert.Empty(t, empty.GetHostname())
+		assert.ErrorIs(t, empty.GrantACL(context.Background()), ErrInvalidSize)
+		_, err := empty.DescribeConsumerGroups(context.Background())
+		assert.ErrorIs(t, err, ErrInvalidSize)
+	}
+}
+
+// metadataTransport answers metadata requests with the response.
+type metadataTransport struct {
+	response *metadata.Response
+}
+
+func (m metadataTransport) RoundTrip(context.Context, net.Addr, kafkaClient.Request) (kafkaClient.Response, error) {
+	return m.response, nil
+}
+
+func TestTopicsAvailable(t *testing.T) {
+	response := &metadata.Response{
+		Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}},
+		Topics: []metadata.ResponseTopic{{Name: "orders", Partitions: []metadata.ResponsePartition{
+			{PartitionIndex: 0, LeaderID: 1},
+			{PartitionIndex: 1, LeaderID: -1},
+		}}},
+	}
+	client := &kafkaClient.Client{Addr: kafkaClient.TCP("localhost:9092"), Transport: metadataTransport{response}}
+	specs := []TopicSpec{{Name: "orders", Partitions: 2}}
+	assert.ErrorContains(t, topicsAvailable(context.Background(), client, specs), "partition 1 of orders has no leader")
+
+	response.Topics[0].Partitions[1].LeaderID = 1
+	assert.NoError(t, topicsAvailable(context.Background(), client, specs))
+	assert.ErrorContains(t, topicsAvailable(context.Background(), client, []TopicSpec{{Name: "payments"}}),
+		"topic payments is not available")
+}
@@ -0,0 +1,212 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ory/dockertest"
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+var ErrTopicMismatch = errors.New("topic does not match its specification")
+
+// TopicSpec describes a topic that is created when kafka is started.
+type TopicSpec struct {
+	Name string
+	// Partitions defaults to 1.
+	Partitions int
+	// ReplicationFactor defaults to the replication factor of the container,
+	// 1 for WithKafka and up to 3 for WithKafkaCluster.
+	ReplicationFactor int
+	// Configs holds topic level configs, e.g. cleanup.policy=compact, retention.ms or min.insync.replicas.
+	Configs map[string]string
+	// Fixtures are files whose records are produced to the topic once it is created, in order. Files ending
+	// with .jsonl hold a KafkaRecord per line, other files use the binary format written by CaptureFixture.
+	// Topic, offset and timestamp of the records are ignored.
+	Fixtures []string
+}
+
+// topicSpecs merges the specs and the bare topic names and applies the defaults.
+func topicSpecs(replicationFactor int, specs []TopicSpec, names ...string) []TopicSpec {
+	all := make([]TopicSpec, 0, len(specs)+len(names))
+	all = append(all, specs...)
+	for _, name := range names {
+		all = append(all, TopicSpec{Name: name})
+	}
+	for i := range all {
+		if all[i].Partitions == 0 {
+			all[i].Partitions = 1
+		}
+		if all[i].ReplicationFactor == 0 {
+			all[i].ReplicationFactor = replicationFactor
+		}
+	}
+	return all
+}
+
+// sortedConfigKeys returns the config names in a stable order.
+func (t TopicSpec) sortedConfigKeys() []string {
+	keys := make([]string, 0, len(t.Configs))
+	for key := range t.Configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// createTopics creates the topics over the kafka protocol and waits until they are visible in the
+// metadata with a leader for every partition. Existing topics are kept, but have to match their spec.
+// Afterwards the topics are seeded with their fixtures.
+func createTopics(ctx context.Context, pool *dockertest.Pool, client *kafkaClient.Client, specs []TopicSpec) error {
+	request := &kafkaClient.CreateTopicsRequest{}
+	for _, spec := range specs {
+		topic := kafkaClient.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+		}
+		for _, key := range spec.sortedConfigKeys() {
+			topic.ConfigEntries = append(topic.ConfigEntries, kafkaClient.ConfigEntry{
+				ConfigName:  key,
+				ConfigValue: spec.Configs[key],
+			})
+		}
+		request.Topics = append(request.Topics, topic)
+	}
+	response, err := client.CreateTopics(ctx, request)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, spec := range specs {
+		if err := response.Errors[spec.Name]; err != nil && !errors.Is(err, kafkaClient.TopicAlreadyExists) {
+			errs = append(errs, fmt.Errorf("unable to create topic %s: %w", spec.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := pool.Retry(func() error {
+		return topicsAvailable(ctx, client, specs)
+	}); err != nil {
+		return err
+	}
+	if err := verifyTopics(ctx, client, specs); err != nil {
+		return err
+	}
+	return seedTopics(ctx, client, specs)
+}
+
+// topicsAvailable checks that all topics are part of the metadata and every partition has a leader.
+func topicsAvailable(ctx context.Context, client *kafkaClient.Client, specs []TopicSpec) error {
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	metadata, err := client.Metadata(ctx, &kafkaClient.MetadataRequest{Topics: names})
+	if err != nil {
+		return err
+	}
+	available := map[string]bool{}
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return fmt.Errorf("topic %s is not available: %w", topic.Name, topic.Error)
+		}
+		for _, partition := range topic.Partitions {
+			if partition.Error != nil {
+				return fmt.Errorf("partition %d of %s is not available: %w", partition.ID, topic.Name, partition.Error)
+			}
+			// Brokers that are not part of the metadata, like the leader -1 during an election, have no host.
+			if partition.Leader.Host == "" {
+				return fmt.Errorf("partition %d of %s has no leader", partition.ID, topic.Name)
+			}
+		}
+		available[topic.Name] = len(topic.Partitions) > 0
+	}
+	for _, name := range names {
+		if !available[name] {
+			return fmt.Errorf("topic %s is not available", name)
+		}
+	}
+	return nil
+}
+
+// verifyTopics checks partitions, replication factor and configs of the created topics.
+func verifyTopics(ctx context.Context, client *kafkaClient.Client, specs []TopicSpec) error {
+	if len(specs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	metadata, err := client.Metadata(ctx, &kafkaClient.MetadataRequest{Topics: names})
+	if err != nil {
+		return err
+	}
+	topics := make(map[string]kafkaClient.Topic, len(metadata.Topics))
+	for _, topic := range metadata.Topics {
+		topics[topic.Name] = topic
+	}
+
+	var configRequest kafkaClient.DescribeConfigsRequest
+	var errs []error
+	for _, spec := range specs {
+		topic, ok := topics[spec.Name]
+		if !ok || topic.Error != nil {
+			errs = append(errs, fmt.Errorf("%w: %s is missing: %v", ErrTopicMismatch, spec.Name, topic.Error))
+			continue
+		}
+		if len(topic.Partitions) != spec.Partitions {
+			errs = append(errs, fmt.Errorf("%w: %s has %d partitions instead of %d",
+				ErrTopicMismatch, spec.Name, len(topic.Partitions), spec.Partitions))
+		}
+		for _, partition := range topic.Partitions {
+			if len(partition.Replicas) != spec.ReplicationFactor {
+				errs = append(errs, fmt.Errorf("%w: partition %d of %s has %d replicas instead of %d",
+					ErrTopicMismatch, partition.ID, spec.Name, len(partition.Replicas), spec.ReplicationFactor))
+			}
+		}
+		if len(spec.Configs) > 0 {
+			configRequest.Resources = append(configRequest.Resources, kafkaClient.DescribeConfigRequestResource{
+				ResourceType: kafkaClient.ResourceTypeTopic,
+				ResourceName: spec.Name,
+				ConfigNames:  spec.sortedConfigKeys(),
+			})
+		}
+	}
+	if len(configRequest.Resources) == 0 {
+		return errors.Join(errs...)
+	}
+
+	configs, err := client.DescribeConfigs(ctx, &configRequest)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, resource := range configs.Resources {
+		if resource.Error != nil {
+			errs = append(errs, fmt.Errorf("unable to describe configs of %s: %w", resource.ResourceName, resource.Error))
+			continue
+		}
+		actual := make(map[string]string, len(resource.ConfigEntries))
+		for _, entry := range resource.ConfigEntries {
+			actual[entry.ConfigName] = entry.ConfigValue
+		}
+		for _, spec := range specs {
+			if spec.Name != resource.ResourceName {
+				continue
+			}
+			for _, key := range spec.sortedConfigKeys() {
+				if actual[key] != spec.Configs[key] {
+					errs = append(errs, fmt.Errorf("%w: %s has %s=%s instead of %s",
+						ErrTopicMismatch, spec.Name, key, strconv.Quote(actual[key]), strconv.Quote(spec.Configs[key])))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package container
+
+import (
+	"strconv"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// PgBouncerPoolMode defines when a server connection is returned to the pool.
+type PgBouncerPoolMode string
+
+const (
+	// PgBouncerSessionPooling assigns a server connection for the whole client session.
+	PgBouncerSessionPooling PgBouncerPoolMode = "session"
+	// PgBouncerTransactionPooling assigns a server connection for a single transaction only.
+	// Session state like prepared statements does not survive the transaction.
+	PgBouncerTransactionPooling PgBouncerPoolMode = "transaction"
+)
+
+type pgBouncer struct {
+	hostName string
+	Port     int
+	Opts     testsetup.DockerContainerOpts
+	r        *dockertest.Resource
+	opts     PgBouncerOpts
+	postgres testsetup.Container
+}
+
+// PgBouncerContainer is a Container running pgbouncer that provides connection details.
+type PgBouncerContainer interface {
+	testsetup.Container
+	// DSN returns a connection string to reach the database through pgbouncer from the outside.
+	DSN() string
+}
+
+type PgBouncerOpts struct {
+	ContainerName string
+	NetworkID     string
+	// PoolMode defaults to PgBouncerSessionPooling.
+	PoolMode PgBouncerPoolMode
+	// DBName, DBUser and DBPass have to match the postgres container.
+	DBName string
+	DBUser string
+	DBPass string
+	// DBInternalPort is the port postgres listens on inside the network. Defaults to "5432".
+	DBInternalPort string
+	// ExternalHost is the hostname the container is reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost string
+	ExternalPort string
+}
+
+// WithPgBouncer returns a Container in order to spawn pgbouncer in front of a postgres container.
+// The postgres container is reached by its hostname within the network, so it has to be
+// started before pgbouncer, i.e. passed before it to testsetup.NewTestSetup.
+func WithPgBouncer(opts PgBouncerOpts, postgres testsetup.Container) PgBouncerContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	if opts.PoolMode == "" {
+		opts.PoolMode = PgBouncerSessionPooling
+	}
+	if opts.DBInternalPort == "" {
+		opts.DBInternalPort = "5432"
+	}
+	port, _ := strconv.Atoi(opts.ExternalPort)
+	p := &pgBouncer{
+		Port:     port,
+		opts:     opts,
+		postgres: postgres,
+		Opts: testsetup.DockerContainerOpts{
+			ContainerName: opts.ContainerName,
+			Repository:    "edoburu/pgbouncer",
+			Tag:           "v1.23.1-p2",
+			PortBinding:   map[string]string{opts.ExternalPort: "6432"},
+			Env: map[string]string{
+				"DB_PORT":     opts.DBInternalPort,
+				"DB_USER":     opts.DBUser,
+				"DB_PASSWORD": opts.DBPass,
+				"DB_NAME":     opts.DBName,
+				"POOL_MODE":   string(opts.PoolMode),
+				"LISTEN_PORT": "6432",
+				// Keeps the password in plain text, so pgbouncer can authenticate with md5 and scram.
+				"AUTH_TYPE": "scram-sha-256",
+			},
+			ExpireTime: 5,
+			NetworkID:  opts.NetworkID,
+		},
+	}
+	p.Opts.HealthCheck = func(pool *dockertest.Pool, _ *dockertest.Resource) error {
+		if err := waitForPostgres(pool, p.DSN()); err != nil {
+			return err
+		}
+		return nil
+	}
+	return p
+}
+
+func (p *pgBouncer) DSN() string {
+	return postgresDSN(p.opts.ExternalHost,
+		p.opts.ExternalPort,
+		p.opts.DBName,
+		p.opts.DBUser,
+		p.opts.DBPass,
+		"disable")
+}
+
+func (p *pgBouncer) GetHostname() string {
+	return p.hostName
+}
+
+func (p *pgBouncer) GetPorts() []int {
+	return []int{p.Port}
+}
+
+func (p *pgBouncer) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	p.Opts.Env["DB_HOST"] = p.postgres.GetHostname()
+	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, p.Opts)
+	if err != nil {
+		return err
+	}
+	p.hostName = *hostname
+	p.r = resource
+	return nil
+}
+
+func (p *pgBouncer) Stop() error {
+	return p.r.Close()
+}
+
+func (p *pgBouncer) SetLabel(label map[string]string) {
+	p.Opts.Labels = label
+}
@@ -0,0 +1,34 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithPgBouncer(t *testing.T) {
+	bouncer := WithPgBouncer(PgBouncerOpts{
+		ContainerName: "bouncer",
+		PoolMode:      PgBouncerTransactionPooling,
+		DBName:        "db",
+		DBUser:        "user",
+		DBPass:        "pass",
+		ExternalHost:  "localhost",
+		ExternalPort:  "6432",
+	}, WithPostgres(PostgresContainerOpts{ContainerName: "postgres"})).(*pgBouncer)
+	assert.Equal(t, []int{6432}, bouncer.GetPorts())
+	assert.Equal(t, map[string]string{"6432": "6432"}, bouncer.Opts.PortBinding)
+	assert.Equal(t, map[string]string{
+		"DB_PORT":     "5432",
+		"DB_USER":     "user",
+		"DB_PASSWORD": "pass",
+		"DB_NAME":     "db",
+		"POOL_MODE":   "transaction",
+		"LISTEN_PORT": "6432",
+		"AUTH_TYPE":   "scram-sha-256",
+	}, bouncer.Opts.Env)
+	assert.Equal(t, "host=localhost port=6432 user=user dbname=db password=pass sslmode=disable", bouncer.DSN())
+
+	session := WithPgBouncer(PgBouncerOpts{ExternalPort: "6433"}, nil).(*pgBouncer)
+	assert.Equal(t, "session", session.Opts.Env["POOL_MODE"])
+}
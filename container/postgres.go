@@ -1,20 +1,62 @@
 package container
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"strings"
+	"testing"
 
 	"github.com/4ND3R50N/testsetup"
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
 )
 
+// postgresTLSDir is the directory inside the container the TLS material is written to.
+const postgresTLSDir = "/var/lib/postgresql/tls"
+
 type postgres struct {
 	hostName string
 	Port     int
 	Opts     testsetup.DockerContainerOpts
 	r        *dockertest.Resource
+	pool     *dockertest.Pool
+	opts     PostgresContainerOpts
+	settings map[string]string
+	// setup holds shell commands that are run as root before the regular entrypoint.
+	setup []string
+	certs *Certificates
+	// statements is nil unless the statement log is enabled.
+	statements     *statementLog
+	stopStatements context.CancelFunc
+	extensions     map[string]string
+}
+
+// PostgresContainer is a Container running postgres that provides connection details.
+type PostgresContainer interface {
+	testsetup.Container
+	// DSN returns a connection string to reach the database from the outside.
+	DSN() string
+	// Certificates returns the generated TLS material or nil if TLS is disabled.
+	Certificates() *Certificates
+	// IsolatedSchema creates a schema private to the test, see Schema.
+	IsolatedSchema(t testing.TB, migrations ...string) *Schema
+	// CaptureQueries captures the statements executed during a test, see QueryCapture.
+	CaptureQueries(t testing.TB) *QueryCapture
+	// RecordChanges records all row changes committed during a test, see ChangeRecorder.
+	RecordChanges(t testing.TB) *ChangeRecorder
+	// Extensions returns the installed version of every requested extension by name.
+	Extensions() map[string]string
+	// Dump writes a dump of the database, see DumpOpts.
+	Dump(ctx context.Context, w io.Writer, opts DumpOpts) error
+	// Restore restores a dump written by Dump.
+	Restore(ctx context.Context, r io.Reader, opts DumpOpts) error
+	// AssertSchemaGolden compares a schema-only dump with a committed golden file.
+	AssertSchemaGolden(t testing.TB, goldenFile string) bool
 }
 
 type PostgresContainerOpts struct {
@@ -30,18 +72,44 @@ type PostgresContainerOpts struct {
 	ExternalDBHost string
 	DBExternalPort string
 	DBInternalPort string
+	// TLS enables TLS with a throwaway CA. If nil, only plaintext connections are possible.
+	TLS *PostgresTLSOpts
+	// StatementLog logs all statements and tails the container log, so they can be inspected with CaptureQueries.
+	StatementLog *PostgresStatementLogOpts
+	// LogicalDecoding starts postgres with wal_level=logical, which is required by RecordChanges.
+	LogicalDecoding bool
+	// Image selects the postgres image, e.g. PostgresImagePostGIS. Defaults to PostgresImageDefault.
+	Image PostgresImage
+	// Extensions are created after the health check and their versions verified.
+	Extensions []PostgresExtension
+}
+
+// PostgresTLSOpts configures TLS for the postgres container.
+type PostgresTLSOpts struct {
+	// RequireClientCert enforces client certificate authentication for all TCP connections.
+	// The client certificate is issued for DBUser.
+	RequireClientCert bool
 }
 
 // WithPostgres returns a Container in order to spawn a postgres container
-func WithPostgres(opts PostgresContainerOpts) testsetup.Container {
+func WithPostgres(opts PostgresContainerOpts) PostgresContainer {
+	return newPostgres(opts)
+}
+
+func newPostgres(opts PostgresContainerOpts) *postgres {
 	opts.ExternalDBHost = validateHost(opts.ExternalDBHost)
+	if opts.Image.Repository == "" {
+		opts.Image = PostgresImageDefault
+	}
 	port, _ := strconv.Atoi(opts.DBExternalPort)
-	return &postgres{
-		Port: port,
+	p := &postgres{
+		Port:     port,
+		opts:     opts,
+		settings: map[string]string{},
 		Opts: testsetup.DockerContainerOpts{
 			ContainerName: opts.ContainerName,
-			Repository:    "postgres",
-			Tag:           "13.1",
+			Repository:    opts.Image.Repository,
+			Tag:           opts.Image.Tag,
 			PortBinding:   map[string]string{opts.DBExternalPort: opts.DBInternalPort},
 			Env: map[string]string{
 				"POSTGRES_DB":       opts.DBName,
@@ -50,37 +118,35 @@ func WithPostgres(opts PostgresContainerOpts) testsetup.Container {
 				"POSTGRES_PORT":     opts.DBInternalPort,
 			},
 			ExpireTime: 5,
-			HealthCheck: func(pool *dockertest.Pool, _ *dockertest.Resource) error {
-				if err := waitForPostgres(pool,
-					opts.ExternalDBHost,
-					opts.DBExternalPort,
-					opts.DBName,
-					opts.DBUser,
-					opts.DBPass,
-					"disable"); err != nil {
-					return err
-				}
-				return nil
-			},
-			NetworkID: opts.NetworkID,
+			NetworkID:  opts.NetworkID,
 		},
 	}
+	p.Opts.HealthCheck = func(pool *dockertest.Pool, _ *dockertest.Resource) error {
+		if err := waitForPostgres(pool, p.DSN()); err != nil {
+			return err
+		}
+		return nil
+	}
+	return p
 }
 
-func waitForPostgres(pool *dockertest.Pool,
-	dbHost string,
+func postgresDSN(dbHost string,
 	dbPort string,
 	dbName string,
 	dbUser string,
 	dbPass string,
-	dbSSLMode string) error {
+	dbSSLMode string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
+		dbHost, dbPort, dbUser, dbName, dbPass, dbSSLMode)
+}
+
+func waitForPostgres(pool *dockertest.Pool, dsn string) error {
 	err := pool.Retry(func() error {
-		dbClient, err := sql.Open("postgres",
-			fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
-				dbHost, dbPort, dbUser, dbName, dbPass, dbSSLMode))
+		dbClient, err := sql.Open("postgres", dsn)
 		if err != nil {
 			return err
 		}
+		defer dbClient.Close()
 		if err := dbClient.Ping(); err != nil {
 			return err
 		}
@@ -92,6 +158,85 @@ func waitForPostgres(pool *dockertest.Pool,
 	return nil
 }
 
+// postgresCommand builds the postgres server command applying all settings as -c flags.
+func postgresCommand(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	command := []string{"postgres"}
+	for _, key := range keys {
+		command = append(command, "-c", key+"="+settings[key])
+	}
+	return command
+}
+
+// enableTLS generates the certificates and adds a setup step so that they are written
+// into the container with the ownership and permissions postgres insists on.
+func (p *postgres) enableTLS() error {
+	certs, err := newCertificates(p.opts.DBUser, p.opts.ContainerName, p.opts.ExternalDBHost, "localhost", "127.0.0.1")
+	if err != nil {
+		return err
+	}
+	p.certs = certs
+	p.Opts.Env["PG_TLS_CA_CERT"] = string(certs.CACert)
+	p.Opts.Env["PG_TLS_SERVER_CERT"] = string(certs.ServerCert)
+	p.Opts.Env["PG_TLS_SERVER_KEY"] = string(certs.ServerKey)
+	p.settings["ssl"] = "on"
+	p.settings["ssl_ca_file"] = postgresTLSDir + "/ca.crt"
+	p.settings["ssl_cert_file"] = postgresTLSDir + "/server.crt"
+	p.settings["ssl_key_file"] = postgresTLSDir + "/server.key"
+
+	script := "mkdir -p " + postgresTLSDir + " && cd " + postgresTLSDir +
+		` && printf '%s' "$PG_TLS_CA_CERT" > ca.crt` +
+		` && printf '%s' "$PG_TLS_SERVER_CERT" > server.crt` +
+		` && printf '%s' "$PG_TLS_SERVER_KEY" > server.key`
+	if p.opts.TLS.RequireClientCert {
+		script += ` && printf 'local all all trust\nhostssl all all all cert\n' > pg_hba.conf`
+		p.settings["hba_file"] = postgresTLSDir + "/pg_hba.conf"
+	}
+	script += " && chown -R postgres:postgres . && chmod 600 server.key && cd /"
+	p.setup = append(p.setup, script)
+	return nil
+}
+
+// DSN returns a connection string to reach the database from the outside.
+// With TLS enabled the server certificate is fully verified and the client certificate is presented.
+func (p *postgres) DSN() string {
+	if p.certs == nil {
+		return postgresDSN(p.opts.ExternalDBHost,
+			p.opts.DBExternalPort,
+			p.opts.DBName,
+			p.opts.DBUser,
+			p.opts.DBPass,
+			"disable")
+	}
+	return postgresDSN(p.opts.ExternalDBHost,
+		p.opts.DBExternalPort,
+		p.opts.DBName,
+		p.opts.DBUser,
+		p.opts.DBPass,
+		"verify-full") +
+		fmt.Sprintf(" sslrootcert=%s sslcert=%s sslkey=%s",
+			p.certs.CACertFile(), p.certs.ClientCertFile(), p.certs.ClientKeyFile())
+}
+
+// RecordChanges records all row changes committed from now on until the test finishes.
+// LogicalDecoding has to be enabled.
+func (p *postgres) RecordChanges(t testing.TB) *ChangeRecorder {
+	t.Helper()
+	return newChangeRecorder(t, p.DSN())
+}
+
+func (p *postgres) Extensions() map[string]string {
+	return p.extensions
+}
+
+func (p *postgres) Certificates() *Certificates {
+	return p.certs
+}
+
 func (p *postgres) GetHostname() string {
 	return p.hostName
 }
@@ -101,17 +246,62 @@ func (p *postgres) GetPorts() []int {
 }
 
 func (p *postgres) Start(_ docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if p.opts.TLS != nil && p.certs == nil {
+		if err := p.enableTLS(); err != nil {
+			return err
+		}
+	}
+	if p.opts.LogicalDecoding {
+		p.settings["wal_level"] = "logical"
+	}
+	if p.opts.StatementLog != nil {
+		p.settings["log_statement"] = "all"
+		p.settings["log_min_duration_statement"] = strconv.FormatInt(p.opts.StatementLog.MinDuration.Milliseconds(), 10)
+		p.settings["log_line_prefix"] = statementLogLinePrefix
+	}
+	if len(p.settings) > 0 {
+		p.Opts.Commands = postgresCommand(p.settings)
+	}
+	if len(p.setup) > 0 {
+		script := strings.Join(p.setup, " && ") + ` && exec docker-entrypoint.sh "$@"`
+		p.Opts.EntryPoint = []string{"/bin/sh", "-c", script, "sh"}
+		if len(p.Opts.Commands) == 0 {
+			p.Opts.Commands = []string{"postgres"}
+		}
+	}
 	resource, hostname, err := testsetup.RunDockerContainer(docker.AuthConfiguration{}, pool, p.Opts)
 	if err != nil {
 		return err
 	}
 	p.hostName = *hostname
 	p.r = resource
+	p.pool = pool
+	if len(p.opts.Extensions) > 0 {
+		p.extensions, err = createExtensions(p.DSN(), p.opts.Extensions)
+		if err != nil {
+			_ = resource.Close()
+			return err
+		}
+	}
+	if p.opts.StatementLog != nil {
+		var ctx context.Context
+		ctx, p.stopStatements = context.WithCancel(context.Background())
+		p.statements = newStatementLog()
+		go p.statements.follow(ctx, pool, resource)
+	}
 	return nil
 }
 
 func (p *postgres) Stop() error {
-	return p.r.Close()
+	if p.stopStatements != nil {
+		p.stopStatements()
+	}
+	err := p.r.Close()
+	if p.certs != nil {
+		// The private keys must not outlive the test, even if the container could not be removed.
+		err = errors.Join(err, p.certs.remove())
+	}
+	return err
 }
 
 func (p *postgres) SetLabel(label map[string]string) {
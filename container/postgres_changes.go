@@ -0,0 +1,289 @@
+package container
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrMalformedChange = errors.New("malformed pgoutput message")
+
+// Change is a row change that was committed to postgres.
+type Change struct {
+	// Operation is one of "INSERT", "UPDATE", "DELETE" or "TRUNCATE".
+	Operation string
+	Schema    string
+	Table     string
+	// Old holds the replica identity of updated and deleted rows. It contains all columns
+	// if the table uses REPLICA IDENTITY FULL and is nil if the key did not change on update.
+	Old map[string]any
+	// New holds the inserted or updated row.
+	// Values are text encoded strings or nil for NULL, unchanged TOAST values are omitted.
+	New map[string]any
+}
+
+// ChangeRecorder records all row changes committed to postgres using logical decoding.
+type ChangeRecorder struct {
+	t           testing.TB
+	db          *sql.DB
+	slot        string
+	publication string
+	relations   map[uint32]pgRelation
+	changes     []Change
+}
+
+type pgRelation struct {
+	schema  string
+	table   string
+	columns []string
+}
+
+// newChangeRecorder creates a publication for all tables and a pgoutput replication slot.
+// Changes are decoded from the slot with the SQL interface, so no replication connection is required.
+func newChangeRecorder(t testing.TB, dsn string) *ChangeRecorder {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("unable to connect to postgres: %s", err)
+	}
+	var walLevel string
+	if err := db.QueryRow("SHOW wal_level").Scan(&walLevel); err != nil {
+		_ = db.Close()
+		t.Fatalf("unable to determine wal_level: %s", err)
+	}
+	if walLevel != "logical" {
+		_ = db.Close()
+		t.Fatalf("wal_level is %q, logical decoding requires \"logical\"", walLevel)
+	}
+
+	name := "testsetup_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	r := &ChangeRecorder{
+		t:           t,
+		db:          db,
+		slot:        name,
+		publication: name,
+		relations:   map[uint32]pgRelation{},
+	}
+	if _, err := db.Exec("CREATE PUBLICATION " + pq.QuoteIdentifier(name) + " FOR ALL TABLES"); err != nil {
+		_ = db.Close()
+		t.Fatalf("unable to create publication: %s", err)
+	}
+	if _, err := db.Exec("SELECT pg_create_logical_replication_slot($1, 'pgoutput')", name); err != nil {
+		_, _ = db.Exec("DROP PUBLICATION " + pq.QuoteIdentifier(name))
+		_ = db.Close()
+		t.Fatalf("unable to create replication slot: %s", err)
+	}
+	t.Cleanup(func() {
+		defer db.Close()
+		if _, err := db.Exec("SELECT pg_drop_replication_slot($1)", name); err != nil {
+			t.Errorf("unable to drop replication slot %s: %s", name, err)
+		}
+		if _, err := db.Exec("DROP PUBLICATION IF EXISTS " + pq.QuoteIdentifier(name)); err != nil {
+			t.Errorf("unable to drop publication %s: %s", name, err)
+		}
+	})
+	return r
+}
+
+// Changes returns all changes committed since the recorder was created, in commit order.
+func (r *ChangeRecorder) Changes() []Change {
+	r.t.Helper()
+	changes, err := r.fetch(context.Background())
+	if err != nil {
+		r.t.Fatalf("unable to fetch changes: %s", err)
+	}
+	return changes
+}
+
+func (r *ChangeRecorder) fetch(ctx context.Context) ([]Change, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT data FROM pg_logical_slot_get_binary_changes($1, NULL, NULL,
+		'proto_version', '1', 'publication_names', $2)`, r.slot, r.publication)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		changes, err := r.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		r.changes = append(r.changes, changes...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	changes := make([]Change, len(r.changes))
+	copy(changes, r.changes)
+	return changes, nil
+}
+
+// decode decodes a single pgoutput message, see
+// https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html
+func (r *ChangeRecorder) decode(data []byte) ([]Change, error) {
+	m := &pgMessage{data: data}
+	switch m.byte() {
+	case 'R':
+		relationID := m.uint32()
+		relation := pgRelation{schema: m.string(), table: m.string()}
+		m.byte() // replica identity
+		columns := int(m.uint16())
+		for i := 0; i < columns; i++ {
+			m.byte() // flags
+			relation.columns = append(relation.columns, m.string())
+			m.uint32() // type
+			m.uint32() // type modifier
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		r.relations[relationID] = relation
+		return nil, nil
+	case 'I':
+		relation, err := r.relation(m.uint32())
+		if err != nil {
+			return nil, err
+		}
+		change := Change{Operation: "INSERT", Schema: relation.schema, Table: relation.table}
+		if m.byte() != 'N' {
+			return nil, ErrMalformedChange
+		}
+		change.New = m.tuple(relation)
+		return []Change{change}, m.err
+	case 'U':
+		relation, err := r.relation(m.uint32())
+		if err != nil {
+			return nil, err
+		}
+		change := Change{Operation: "UPDATE", Schema: relation.schema, Table: relation.table}
+		kind := m.byte()
+		if kind == 'K' || kind == 'O' {
+			change.Old = m.tuple(relation)
+			kind = m.byte()
+		}
+		if kind != 'N' {
+			return nil, ErrMalformedChange
+		}
+		change.New = m.tuple(relation)
+		return []Change{change}, m.err
+	case 'D':
+		relation, err := r.relation(m.uint32())
+		if err != nil {
+			return nil, err
+		}
+		change := Change{Operation: "DELETE", Schema: relation.schema, Table: relation.table}
+		if kind := m.byte(); kind != 'K' && kind != 'O' {
+			return nil, ErrMalformedChange
+		}
+		change.Old = m.tuple(relation)
+		return []Change{change}, m.err
+	case 'T':
+		relations := int(m.uint32())
+		m.byte() // options
+		var changes []Change
+		for i := 0; i < relations; i++ {
+			relation, err := r.relation(m.uint32())
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, Change{Operation: "TRUNCATE", Schema: relation.schema, Table: relation.table})
+		}
+		return changes, m.err
+	default:
+		// Begin, commit, origin and type messages do not describe row changes.
+		return nil, m.err
+	}
+}
+
+func (r *ChangeRecorder) relation(id uint32) (pgRelation, error) {
+	relation, ok := r.relations[id]
+	if !ok {
+		return pgRelation{}, fmt.Errorf("%w: unknown relation %d", ErrMalformedChange, id)
+	}
+	return relation, nil
+}
+
+// zeroField is returned for fixed size fields beyond the end of a message.
+var zeroField [4]byte
+
+// pgMessage reads the fields of a pgoutput message. The first read beyond the end sets err.
+type pgMessage struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes, or nil once the message is exhausted. n is usually read from the message,
+// so nothing is allocated based on it.
+func (m *pgMessage) next(n int) []byte {
+	if m.err != nil || n < 0 || len(m.data) < n {
+		m.err = ErrMalformedChange
+		return nil
+	}
+	b := m.data[:n]
+	m.data = m.data[n:]
+	return b
+}
+
+// fixed returns the next n bytes of a field of at most four bytes, which are zero once the message is exhausted.
+func (m *pgMessage) fixed(n int) []byte {
+	if b := m.next(n); b != nil {
+		return b
+	}
+	return zeroField[:n]
+}
+
+func (m *pgMessage) byte() byte {
+	return m.fixed(1)[0]
+}
+
+func (m *pgMessage) uint16() uint16 {
+	return binary.BigEndian.Uint16(m.fixed(2))
+}
+
+func (m *pgMessage) uint32() uint32 {
+	return binary.BigEndian.Uint32(m.fixed(4))
+}
+
+func (m *pgMessage) string() string {
+	for i, b := range m.data {
+		if b == 0 {
+			s := string(m.data[:i])
+			m.data = m.data[i+1:]
+			return s
+		}
+	}
+	m.err = ErrMalformedChange
+	return ""
+}
+
+func (m *pgMessage) tuple(relation pgRelation) map[string]any {
+	columns := int(m.uint16())
+	values := make(map[string]any, columns)
+	for i := 0; i < columns && m.err == nil; i++ {
+		name := fmt.Sprintf("column%d", i)
+		if i < len(relation.columns) {
+			name = relation.columns[i]
+		}
+		switch m.byte() {
+		case 'n':
+			values[name] = nil
+		case 'u':
+			// Unchanged TOAST value, the actual value is not part of the message.
+		case 't':
+			values[name] = string(m.next(int(m.uint32())))
+		default:
+			m.err = ErrMalformedChange
+		}
+	}
+	return values
+}
@@ -0,0 +1,72 @@
+package container
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pgMessageBuilder []byte
+
+func (b pgMessageBuilder) byte(v byte) pgMessageBuilder { return append(b, v) }
+func (b pgMessageBuilder) uint16(v uint16) pgMessageBuilder {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+func (b pgMessageBuilder) uint32(v uint32) pgMessageBuilder {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+func (b pgMessageBuilder) string(v string) pgMessageBuilder { return append(append(b, v...), 0) }
+func (b pgMessageBuilder) text(v string) pgMessageBuilder {
+	return append(b.byte('t').uint32(uint32(len(v))), v...)
+}
+
+func TestChangeRecorder_Decode(t *testing.T) {
+	r := &ChangeRecorder{relations: map[uint32]pgRelation{}}
+	relation := pgMessageBuilder{}.byte('R').uint32(16384).string("public").string("items").byte('d').uint16(2).
+		byte(1).string("id").uint32(23).uint32(0xffffffff).
+		byte(0).string("name").uint32(25).uint32(0xffffffff)
+	changes, err := r.decode(relation)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	messages := []pgMessageBuilder{
+		pgMessageBuilder{}.byte('B').uint32(0).uint32(1).uint32(0).uint32(2).uint32(3),
+		pgMessageBuilder{}.byte('I').uint32(16384).byte('N').uint16(2).text("1").text("first"),
+		pgMessageBuilder{}.byte('U').uint32(16384).byte('N').uint16(2).text("1").byte('n'),
+		pgMessageBuilder{}.byte('U').uint32(16384).byte('O').uint16(2).text("1").byte('n').
+			byte('N').uint16(2).text("2").byte('u'),
+		pgMessageBuilder{}.byte('D').uint32(16384).byte('K').uint16(2).text("2").byte('n'),
+		pgMessageBuilder{}.byte('T').uint32(1).byte(0).uint32(16384),
+	}
+	for _, message := range messages {
+		decoded, err := r.decode(message)
+		require.NoError(t, err)
+		changes = append(changes, decoded...)
+	}
+	assert.Equal(t, []Change{
+		{Operation: "INSERT", Schema: "public", Table: "items", New: map[string]any{"id": "1", "name": "first"}},
+		{Operation: "UPDATE", Schema: "public", Table: "items", New: map[string]any{"id": "1", "name": nil}},
+		{Operation: "UPDATE", Schema: "public", Table: "items",
+			Old: map[string]any{"id": "1", "name": nil}, New: map[string]any{"id": "2"}},
+		{Operation: "DELETE", Schema: "public", Table: "items", Old: map[string]any{"id": "2", "name": nil}},
+		{Operation: "TRUNCATE", Schema: "public", Table: "items"},
+	}, changes)
+
+	_, err = r.decode(pgMessageBuilder{}.byte('I').uint32(1).byte('N').uint16(0))
+	assert.ErrorIs(t, err, ErrMalformedChange)
+	_, err = r.decode(pgMessageBuilder{}.byte('I').uint32(16384).byte('N').uint16(1).byte('t').uint32(10))
+	assert.ErrorIs(t, err, ErrMalformedChange)
+}
+
+func TestPgMessageTruncatedLength(t *testing.T) {
+	m := &pgMessage{data: pgMessageBuilder{}.uint32(0xFFFFFFF0)}
+	assert.Nil(t, m.next(int(m.uint32())), "a length from the message must not size an allocation")
+	assert.ErrorIs(t, m.err, ErrMalformedChange)
+	assert.Zero(t, m.uint32(), "reads after the error return zero")
+
+	m = &pgMessage{data: pgMessageBuilder{}.uint16(1).byte('t').uint32(0xFFFFFFF0)}
+	m.tuple(pgRelation{columns: []string{"name"}})
+	assert.ErrorIs(t, m.err, ErrMalformedChange)
+}
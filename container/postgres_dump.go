@@ -0,0 +1,210 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+)
+
+// UpdateGoldenEnv is the environment variable that makes AssertSchemaGolden rewrite the golden files
+// instead of comparing against them, e.g. UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+var ErrNotStarted = errors.New("container is not started")
+
+// DumpFormat is the output format of pg_dump.
+type DumpFormat string
+
+const (
+	// DumpFormatCustom is the compressed archive format of pg_dump that is restored with pg_restore.
+	DumpFormatCustom DumpFormat = "custom"
+	// DumpFormatPlain is a plain SQL script that is restored with psql.
+	DumpFormatPlain DumpFormat = "plain"
+)
+
+// DumpOpts configures Dump and Restore.
+type DumpOpts struct {
+	// Format defaults to DumpFormatCustom. Restore has to use the format of the dump.
+	Format DumpFormat
+	// SchemaOnly dumps the object definitions only, without data.
+	SchemaOnly bool
+}
+
+// postgresClient runs the postgres client tools inside a postgres container.
+// Without host they connect through the local socket, which the postgres image trusts regardless of the OS user.
+// The supabase image only allows peer authentication on the socket, so it connects over TCP with the password.
+type postgresClient struct {
+	pool     *dockertest.Pool
+	resource *dockertest.Resource
+	host     string
+	user     string
+	password string
+	dbName   string
+}
+
+// exec runs the client tool with the connection options of the client.
+func (c postgresClient) exec(ctx context.Context, tool string, args []string, opts testsetup.ExecOpts) error {
+	opts.Cmd = []string{tool, "--username", c.user, "--dbname", c.dbName}
+	if c.host != "" {
+		opts.Cmd = append(opts.Cmd, "--host", c.host)
+		opts.Env = map[string]string{"PGPASSWORD": c.password}
+	}
+	opts.Cmd = append(opts.Cmd, args...)
+	return testsetup.ExecInContainer(ctx, c.pool, c.resource, opts)
+}
+
+func (c postgresClient) dump(ctx context.Context, w io.Writer, opts DumpOpts) error {
+	if c.resource == nil {
+		return ErrNotStarted
+	}
+	if opts.Format == "" {
+		opts.Format = DumpFormatCustom
+	}
+	args := []string{"--format", string(opts.Format)}
+	if opts.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	return c.exec(ctx, "pg_dump", args, testsetup.ExecOpts{Stdout: w})
+}
+
+func (c postgresClient) restore(ctx context.Context, r io.Reader, opts DumpOpts) error {
+	if c.resource == nil {
+		return ErrNotStarted
+	}
+	if opts.Format == DumpFormatPlain {
+		return c.exec(ctx, "psql", []string{"--quiet", "-v", "ON_ERROR_STOP=1"}, testsetup.ExecOpts{Stdin: r})
+	}
+	return c.exec(ctx, "pg_restore", []string{"--exit-on-error"}, testsetup.ExecOpts{Stdin: r})
+}
+
+// assertSchemaGolden compares a schema-only dump with the golden file.
+func (c postgresClient) assertSchemaGolden(t testing.TB, goldenFile string) bool {
+	t.Helper()
+	var dump bytes.Buffer
+	if err := c.dump(context.Background(), &dump, DumpOpts{Format: DumpFormatPlain, SchemaOnly: true}); err != nil {
+		t.Fatalf("unable to dump schema: %s", err)
+	}
+	actual := normalizeSchemaDump(dump.String())
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatalf("unable to create directory of golden file: %s", err)
+		}
+		if err := os.WriteFile(goldenFile, []byte(actual), 0644); err != nil {
+			t.Fatalf("unable to update golden file: %s", err)
+		}
+		return true
+	}
+	expected, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("unable to read golden file, run with %s=1 to create it: %s", UpdateGoldenEnv, err)
+	}
+	if difference := firstDifference(string(expected), actual); difference != "" {
+		t.Errorf("schema differs from %s at %s, run with %s=1 to update it", goldenFile, difference, UpdateGoldenEnv)
+		return false
+	}
+	return true
+}
+
+// firstDifference describes the first line that differs between expected and actual, or returns "" if they are equal.
+func firstDifference(expected string, actual string) string {
+	if expected == actual {
+		return ""
+	}
+	expectedLines, actualLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	for i := 0; ; i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a || i >= len(expectedLines) || i >= len(actualLines) {
+			return fmt.Sprintf("line %d: expected %q, got %q", i+1, e, a)
+		}
+	}
+}
+
+// normalizeSchemaDump removes comments and settings from a plain dump, which differ between
+// pg_dump versions and runs without any change of the schema.
+func normalizeSchemaDump(dump string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(dump, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case strings.HasPrefix(line, "--"),
+			strings.HasPrefix(line, "SET "),
+			strings.HasPrefix(line, "SELECT pg_catalog.set_config("),
+			strings.HasPrefix(line, `\restrict`),
+			strings.HasPrefix(line, `\unrestrict`):
+			continue
+		case line == "":
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Dump writes a dump of the database created by pg_dump inside the container.
+func (p *postgres) Dump(ctx context.Context, w io.Writer, opts DumpOpts) error {
+	return p.client().dump(ctx, w, opts)
+}
+
+// Restore restores a dump created by Dump with pg_restore or psql inside the container.
+func (p *postgres) Restore(ctx context.Context, r io.Reader, opts DumpOpts) error {
+	return p.client().restore(ctx, r, opts)
+}
+
+// AssertSchemaGolden compares a schema-only dump with the golden file. Set UpdateGoldenEnv to rewrite it.
+func (p *postgres) AssertSchemaGolden(t testing.TB, goldenFile string) bool {
+	t.Helper()
+	return p.client().assertSchemaGolden(t, goldenFile)
+}
+
+func (p *postgres) client() postgresClient {
+	return postgresClient{pool: p.pool, resource: p.r, user: p.opts.DBUser, dbName: p.opts.DBName}
+}
+
+// Dump writes a dump of the database created by pg_dump inside the container.
+func (s *supabasePostgres) Dump(ctx context.Context, w io.Writer, opts DumpOpts) error {
+	return s.client().dump(ctx, w, opts)
+}
+
+// Restore restores a dump created by Dump with pg_restore or psql inside the container.
+func (s *supabasePostgres) Restore(ctx context.Context, r io.Reader, opts DumpOpts) error {
+	return s.client().restore(ctx, r, opts)
+}
+
+// AssertSchemaGolden compares a schema-only dump with the golden file. Set UpdateGoldenEnv to rewrite it.
+func (s *supabasePostgres) AssertSchemaGolden(t testing.TB, goldenFile string) bool {
+	t.Helper()
+	return s.client().assertSchemaGolden(t, goldenFile)
+}
+
+func (s *supabasePostgres) client() postgresClient {
+	return postgresClient{
+		pool:     s.pool,
+		resource: s.r,
+		host:     "127.0.0.1",
+		user:     "postgres",
+		password: s.opts.DBPass,
+		dbName:   s.opts.DBName,
+	}
+}
@@ -0,0 +1,42 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSchemaDump(t *testing.T) {
+	dump := `--
+-- PostgreSQL database dump
+--
+
+\restrict abc123
+-- Dumped from database version 13.1
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+
+CREATE TABLE public.items (
+    id integer   
+);
+
+
+ALTER TABLE public.items OWNER TO test;
+
+\unrestrict abc123
+`
+	assert.Equal(t, `CREATE TABLE public.items (
+    id integer
+);
+
+ALTER TABLE public.items OWNER TO test;
+`, normalizeSchemaDump(dump))
+}
+
+func TestFirstDifference(t *testing.T) {
+	assert.Empty(t, firstDifference("a\nb\n", "a\nb\n"))
+	assert.Equal(t, `line 2: expected "b", got "c"`, firstDifference("a\nb\n", "a\nc\n"))
+	assert.Equal(t, `line 3: expected "", got "c"`, firstDifference("a\nb", "a\nb\nc"))
+}
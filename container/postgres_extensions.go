@@ -0,0 +1,63 @@
+package container
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// PostgresImage is a docker image compatible with the official postgres image.
+type PostgresImage struct {
+	Repository string
+	Tag        string
+}
+
+var (
+	// PostgresImageDefault is the plain postgres image used if no image is set.
+	PostgresImageDefault = PostgresImage{Repository: "postgres", Tag: "13.1"}
+	// PostgresImagePostGIS ships the postgis extensions.
+	PostgresImagePostGIS = PostgresImage{Repository: "postgis/postgis", Tag: "16-3.4"}
+	// PostgresImagePgVector ships the vector extension.
+	PostgresImagePgVector = PostgresImage{Repository: "pgvector/pgvector", Tag: "0.7.4-pg16"}
+	// PostgresImageTimescaleDB ships the timescaledb extension preloaded.
+	PostgresImageTimescaleDB = PostgresImage{Repository: "timescale/timescaledb", Tag: "2.15.3-pg16"}
+)
+
+// PostgresExtension is an extension that is created once postgres is healthy.
+type PostgresExtension struct {
+	// Name of the extension, e.g. "postgis", "vector" or "timescaledb".
+	Name string
+	// Version is the required version. If empty, the default version of the image is installed.
+	Version string
+}
+
+// createExtensions creates all extensions and verifies the installed versions.
+func createExtensions(dsn string, extensions []PostgresExtension) (map[string]string, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	versions := make(map[string]string, len(extensions))
+	for _, extension := range extensions {
+		statement := "CREATE EXTENSION IF NOT EXISTS " + pq.QuoteIdentifier(extension.Name)
+		if extension.Version != "" {
+			statement += " VERSION " + pq.QuoteLiteral(extension.Version)
+		}
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("unable to create extension %s: %w", extension.Name, err)
+		}
+		var version string
+		if err := db.QueryRow("SELECT extversion FROM pg_extension WHERE extname = $1", extension.Name).
+			Scan(&version); err != nil {
+			return nil, fmt.Errorf("unable to verify extension %s: %w", extension.Name, err)
+		}
+		if extension.Version != "" && version != extension.Version {
+			return nil, fmt.Errorf("extension %s has version %s instead of %s", extension.Name, version, extension.Version)
+		}
+		versions[extension.Name] = version
+	}
+	return versions, nil
+}
@@ -0,0 +1,204 @@
+package container
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+var (
+	ErrReplicationNotCaughtUp = errors.New("replicas have not caught up with the primary")
+	ErrTLSUnsupported         = errors.New("TLS is not supported")
+)
+
+type postgresReplicated struct {
+	primary  *postgres
+	replicas []*postgres
+	// unsupported is returned on start, e.g. if TLS was requested for the primary.
+	unsupported error
+}
+
+// PostgresReplicatedContainer is a Container running a postgres primary with streaming replicas.
+type PostgresReplicatedContainer interface {
+	testsetup.Container
+	// WriteDSN returns a connection string to reach the primary from the outside.
+	WriteDSN() string
+	// ReadDSNs returns a connection string for every replica to reach it from the outside.
+	ReadDSNs() []string
+	// WaitForReplication blocks until all replicas replayed everything written to the primary.
+	WaitForReplication(ctx context.Context) error
+	// PauseReplay pauses applying the WAL on the replica with the given index to simulate replication lag.
+	PauseReplay(ctx context.Context, replica int) error
+	// ResumeReplay resumes applying the WAL on the replica with the given index.
+	ResumeReplay(ctx context.Context, replica int) error
+}
+
+// PostgresReplicatedOpts configures the primary and the replicas.
+type PostgresReplicatedOpts struct {
+	// Primary configures the primary. TLS is not supported for replicated setups, the start fails if it is set.
+	Primary PostgresContainerOpts
+	// ReplicaExternalPorts holds the external port of every replica, one replica is started per port.
+	// Replicas are named after the primary with a "-replica-<index>" suffix.
+	ReplicaExternalPorts []string
+}
+
+// WithPostgresReplicated returns a Container in order to spawn a postgres primary and
+// streaming replicas that are cloned with pg_basebackup and use one replication slot each.
+// Start returns once all replicas are streaming and caught up with the primary.
+func WithPostgresReplicated(opts PostgresReplicatedOpts) PostgresReplicatedContainer {
+	var unsupported error
+	if opts.Primary.TLS != nil {
+		unsupported = fmt.Errorf("%w: replicated postgres does not support TLS", ErrTLSUnsupported)
+		// No certificates are generated for a setup that never starts.
+		opts.Primary.TLS = nil
+	}
+	primary := newPostgres(opts.Primary)
+	// Replication connections are not covered by the default "all" entry of pg_hba.conf.
+	primary.setup = append(primary.setup,
+		`printf '%s\n' 'echo "host replication all all md5" >> "$PGDATA/pg_hba.conf"'`+
+			" > /docker-entrypoint-initdb.d/replication.sh")
+
+	replicas := make([]*postgres, 0, len(opts.ReplicaExternalPorts))
+	for i, port := range opts.ReplicaExternalPorts {
+		replicaOpts := opts.Primary
+		replicaOpts.ContainerName = opts.Primary.ContainerName + "-replica-" + strconv.Itoa(i)
+		replicaOpts.DBExternalPort = port
+		// Extensions are replicated from the primary, replicas are read only.
+		replicaOpts.Extensions = nil
+		replicas = append(replicas, newPostgres(replicaOpts))
+	}
+	return &postgresReplicated{
+		primary:     primary,
+		replicas:    replicas,
+		unsupported: unsupported,
+	}
+}
+
+func (p *postgresReplicated) GetHostname() string {
+	return p.primary.GetHostname()
+}
+
+func (p *postgresReplicated) GetPorts() []int {
+	ports := p.primary.GetPorts()
+	for _, replica := range p.replicas {
+		ports = append(ports, replica.GetPorts()...)
+	}
+	return ports
+}
+
+func (p *postgresReplicated) Size() int {
+	return 1 + len(p.replicas)
+}
+
+func (p *postgresReplicated) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if p.unsupported != nil {
+		return p.unsupported
+	}
+	if err := p.primary.Start(auth, pool); err != nil {
+		return err
+	}
+	for i, replica := range p.replicas {
+		// The data directory is only cloned on the first start, the entrypoint skips initdb afterwards.
+		replica.setup = append(replica.setup, fmt.Sprintf(`if [ ! -s "$PGDATA/PG_VERSION" ]; then `+
+			`PGPASSWORD="$POSTGRES_PASSWORD" pg_basebackup -h %s -p %s -U "$POSTGRES_USER" -D "$PGDATA" `+
+			`-X stream -C -S replica_%d -R -w && chown -R postgres:postgres "$PGDATA" && chmod 700 "$PGDATA"; fi`,
+			p.primary.GetHostname(), p.primary.opts.DBInternalPort, i))
+		if err := replica.Start(auth, pool); err != nil {
+			return err
+		}
+	}
+	return pool.Retry(func() error {
+		return p.caughtUp(context.Background())
+	})
+}
+
+func (p *postgresReplicated) caughtUp(ctx context.Context) error {
+	db, err := sql.Open("postgres", p.primary.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var streaming int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM pg_stat_replication
+		WHERE state = 'streaming' AND replay_lsn >= pg_current_wal_lsn()`).Scan(&streaming); err != nil {
+		return err
+	}
+	if streaming != len(p.replicas) {
+		return fmt.Errorf("%w: %d of %d replicas are in sync", ErrReplicationNotCaughtUp, streaming, len(p.replicas))
+	}
+	return nil
+}
+
+func (p *postgresReplicated) WaitForReplication(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		err := p.caughtUp(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *postgresReplicated) PauseReplay(ctx context.Context, replica int) error {
+	return p.execOnReplica(ctx, replica, "SELECT pg_wal_replay_pause()")
+}
+
+func (p *postgresReplicated) ResumeReplay(ctx context.Context, replica int) error {
+	return p.execOnReplica(ctx, replica, "SELECT pg_wal_replay_resume()")
+}
+
+func (p *postgresReplicated) execOnReplica(ctx context.Context, replica int, query string) error {
+	if replica < 0 || replica >= len(p.replicas) {
+		return fmt.Errorf("replica %d does not exist", replica)
+	}
+	db, err := sql.Open("postgres", p.replicas[replica].DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, query)
+	return err
+}
+
+func (p *postgresReplicated) WriteDSN() string {
+	return p.primary.DSN()
+}
+
+func (p *postgresReplicated) ReadDSNs() []string {
+	dsns := make([]string, 0, len(p.replicas))
+	for _, replica := range p.replicas {
+		dsns = append(dsns, replica.DSN())
+	}
+	return dsns
+}
+
+func (p *postgresReplicated) Stop() error {
+	var errs []error
+	for _, replica := range p.replicas {
+		if replica.r != nil {
+			errs = append(errs, replica.Stop())
+		}
+	}
+	errs = append(errs, p.primary.Stop())
+	return errors.Join(errs...)
+}
+
+func (p *postgresReplicated) SetLabel(label map[string]string) {
+	p.primary.SetLabel(label)
+	for _, replica := range p.replicas {
+		replica.SetLabel(label)
+	}
+}
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Schema is a schema that is private to a single test.
+type Schema struct {
+	// Name is the generated schema name.
+	Name string
+	// DSN is a connection string with search_path set to the schema.
+	DSN string
+	// DB is a connection pool with search_path set to the schema.
+	DB *sql.DB
+}
+
+// IsolatedSchema creates a uniquely named schema, runs the migrations into it and returns
+// a connection pool that uses it as search_path. Unqualified names in migrations and queries
+// resolve to the schema, so tests sharing one postgres container do not see each other's data.
+// The schema is dropped with CASCADE when the test finishes.
+func (p *postgres) IsolatedSchema(t testing.TB, migrations ...string) *Schema {
+	t.Helper()
+	name := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+
+	admin, err := sql.Open("postgres", p.DSN())
+	if err != nil {
+		t.Fatalf("unable to connect to postgres: %s", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec("CREATE SCHEMA " + pq.QuoteIdentifier(name) +
+		" AUTHORIZATION " + pq.QuoteIdentifier(p.opts.DBUser)); err != nil {
+		t.Fatalf("unable to create schema %s: %s", name, err)
+	}
+
+	schema := &Schema{
+		Name: name,
+		DSN:  p.DSN() + " search_path=" + name,
+	}
+	schema.DB, err = sql.Open("postgres", schema.DSN)
+	if err != nil {
+		t.Fatalf("unable to connect to schema %s: %s", name, err)
+	}
+	t.Cleanup(func() {
+		_ = schema.DB.Close()
+		admin, err := sql.Open("postgres", p.DSN())
+		if err != nil {
+			t.Errorf("unable to connect to postgres: %s", err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + pq.QuoteIdentifier(name) + " CASCADE"); err != nil {
+			t.Errorf("unable to drop schema %s: %s", name, err)
+		}
+	})
+
+	for i, migration := range migrations {
+		if _, err := schema.DB.Exec(migration); err != nil {
+			t.Fatalf("unable to run migration %d in schema %s: %s", i, name, err)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,280 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// statementLogLinePrefix is the log_line_prefix the parser relies on: timestamp and backend pid.
+const statementLogLinePrefix = "%m [%p] "
+
+// statementMarker is the prefix of the queries that mark the boundaries of a capture window.
+const statementMarker = "testsetup-capture-marker:"
+
+var (
+	statementLogLine  = regexp.MustCompile(`^\S+ \S+ \S+ \[(\d+)\] ([A-Z]+):  (.*)$`)
+	statementDuration = regexp.MustCompile(`^duration: ([0-9.]+) ms(?:  (.*))?$`)
+	statementExecute  = regexp.MustCompile(`^execute [^:]*: (.*)$`)
+)
+
+// PostgresStatementLogOpts configures the statement log of the postgres container.
+type PostgresStatementLogOpts struct {
+	// MinDuration is passed as log_min_duration_statement. Durations are only reported
+	// for statements that took at least this long. Defaults to 0, which reports all durations.
+	MinDuration time.Duration
+}
+
+// Query is a statement that was executed by postgres.
+type Query struct {
+	Statement string
+	// Parameters holds the bind parameters as logged by postgres, e.g. "$1 = '42'".
+	Parameters string
+	// Duration is zero if the statement was faster than MinDuration or failed.
+	Duration time.Duration
+	// Error holds the error message if the statement failed.
+	Error string
+}
+
+func (q Query) String() string {
+	s := q.Statement
+	if q.Parameters != "" {
+		s += " [" + q.Parameters + "]"
+	}
+	if q.Duration > 0 {
+		s += " (" + q.Duration.String() + ")"
+	}
+	if q.Error != "" {
+		s += " ERROR: " + q.Error
+	}
+	return s
+}
+
+// statementLog collects all statements that postgres wrote to its log.
+type statementLog struct {
+	mu      sync.Mutex
+	updated chan struct{}
+	queries []Query
+	markers map[string]int
+
+	// pending maps a backend pid to the index of the query that is still running.
+	pending map[string]int
+	// failed maps a backend pid to the index of a query whose STATEMENT line is still expected.
+	failed map[string]int
+	// continued points to the field that continuation lines of a multi-line message are appended to.
+	continued *continuation
+}
+
+type continuation struct {
+	index int
+	field func(q *Query) *string
+}
+
+func newStatementLog() *statementLog {
+	return &statementLog{
+		updated: make(chan struct{}),
+		markers: map[string]int{},
+		pending: map[string]int{},
+		failed:  map[string]int{},
+	}
+}
+
+// follow parses the container log until the container stops or ctx is done.
+func (l *statementLog) follow(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) {
+	reader, writer := io.Pipe()
+	go func() {
+		err := pool.Client.Logs(docker.LogsOptions{
+			Context:      ctx,
+			Container:    resource.Container.ID,
+			OutputStream: writer,
+			ErrorStream:  writer,
+			Follow:       true,
+			Stdout:       true,
+			Stderr:       true,
+		})
+		_ = writer.CloseWithError(err)
+	}()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		l.parseLine(scanner.Text())
+	}
+}
+
+func (l *statementLog) parseLine(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.notify()
+
+	match := statementLogLine.FindStringSubmatch(line)
+	if match == nil {
+		if l.continued != nil {
+			field := l.continued.field(&l.queries[l.continued.index])
+			*field += "\n" + strings.TrimPrefix(line, "\t")
+		}
+		return
+	}
+	l.continued = nil
+	pid, level, message := match[1], match[2], match[3]
+
+	switch {
+	case level == "LOG" && strings.HasPrefix(message, "statement: "):
+		l.begin(pid, strings.TrimPrefix(message, "statement: "))
+	case level == "LOG" && statementExecute.MatchString(message):
+		l.begin(pid, statementExecute.FindStringSubmatch(message)[1])
+	case level == "LOG" && statementDuration.MatchString(message):
+		durationMatch := statementDuration.FindStringSubmatch(message)
+		if durationMatch[2] != "" {
+			// Durations of the parse and bind steps of the extended protocol.
+			return
+		}
+		index, ok := l.pending[pid]
+		if !ok {
+			return
+		}
+		delete(l.pending, pid)
+		ms, _ := strconv.ParseFloat(durationMatch[1], 64)
+		l.queries[index].Duration = time.Duration(ms * float64(time.Millisecond))
+	case level == "DETAIL" && strings.HasPrefix(message, "parameters: "):
+		if index, ok := l.pending[pid]; ok {
+			l.queries[index].Parameters = strings.TrimPrefix(message, "parameters: ")
+			l.continued = &continuation{index: index, field: func(q *Query) *string { return &q.Parameters }}
+		}
+	case level == "ERROR" || level == "FATAL":
+		index, ok := l.pending[pid]
+		if !ok {
+			// The statement was not logged before, e.g. due to a syntax error. It follows in a STATEMENT line.
+			l.queries = append(l.queries, Query{})
+			index = len(l.queries) - 1
+			l.failed[pid] = index
+		}
+		delete(l.pending, pid)
+		l.queries[index].Error = message
+		l.continued = &continuation{index: index, field: func(q *Query) *string { return &q.Error }}
+	case level == "STATEMENT":
+		if index, ok := l.failed[pid]; ok {
+			delete(l.failed, pid)
+			l.queries[index].Statement = message
+			l.continued = &continuation{index: index, field: func(q *Query) *string { return &q.Statement }}
+		}
+	}
+}
+
+func (l *statementLog) begin(pid string, statement string) {
+	delete(l.pending, pid)
+	if strings.Contains(statement, statementMarker) {
+		id := statement[strings.Index(statement, statementMarker)+len(statementMarker):]
+		l.markers[strings.TrimSuffix(id, "'")] = len(l.queries)
+		return
+	}
+	l.queries = append(l.queries, Query{Statement: statement})
+	l.pending[pid] = len(l.queries) - 1
+	l.continued = &continuation{index: len(l.queries) - 1, field: func(q *Query) *string { return &q.Statement }}
+}
+
+// notify wakes up everyone waiting for new log lines. Must be called with the lock held.
+func (l *statementLog) notify() {
+	close(l.updated)
+	l.updated = make(chan struct{})
+}
+
+// mark executes a marker query and returns the number of queries logged before it.
+func (l *statementLog) mark(ctx context.Context, db *sql.DB) (int, error) {
+	id := uuid.New().String()
+	if _, err := db.ExecContext(ctx, "SELECT '"+statementMarker+id+"'"); err != nil {
+		return 0, err
+	}
+	for {
+		l.mu.Lock()
+		index, ok := l.markers[id]
+		updated := l.updated
+		l.mu.Unlock()
+		if ok {
+			return index, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("marker did not show up in the postgres log: %w", ctx.Err())
+		case <-updated:
+		}
+	}
+}
+
+func (l *statementLog) between(from int, to int) []Query {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	queries := make([]Query, to-from)
+	copy(queries, l.queries[from:to])
+	return queries
+}
+
+// QueryCapture collects the statements postgres executed since it was created.
+type QueryCapture struct {
+	t     testing.TB
+	log   *statementLog
+	db    *sql.DB
+	start int
+}
+
+// CaptureQueries starts capturing all statements executed by postgres, regardless of the connection.
+// If the test fails, the captured statements are logged as SQL transcript.
+func (p *postgres) CaptureQueries(t testing.TB) *QueryCapture {
+	t.Helper()
+	if p.statements == nil {
+		t.Fatalf("statement log is not enabled, set StatementLog in PostgresContainerOpts")
+	}
+	db, err := sql.Open("postgres", p.DSN())
+	if err != nil {
+		t.Fatalf("unable to connect to postgres: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start, err := p.statements.mark(ctx, db)
+	if err != nil {
+		_ = db.Close()
+		t.Fatalf("unable to start capturing queries: %s", err)
+	}
+	c := &QueryCapture{t: t, log: p.statements, db: db, start: start}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("SQL transcript:\n%s", c.Transcript())
+		}
+		_ = db.Close()
+	})
+	return c
+}
+
+// Queries returns all statements that were executed since the capture started.
+func (c *QueryCapture) Queries() []Query {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	end, err := c.log.mark(ctx, c.db)
+	if err != nil {
+		c.t.Fatalf("unable to collect queries: %s", err)
+	}
+	return c.log.between(c.start, end)
+}
+
+// Transcript returns all statements that were executed since the capture started, one per line.
+func (c *QueryCapture) Transcript() string {
+	c.t.Helper()
+	var b strings.Builder
+	for _, q := range c.Queries() {
+		b.WriteString(q.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementLog_ParseLine(t *testing.T) {
+	log := newStatementLog()
+	for _, line := range []string{
+		"2024-01-02 10:00:00.000 UTC [42] LOG:  statement: SELECT 'testsetup-capture-marker:start'",
+		"2024-01-02 10:00:00.001 UTC [42] LOG:  duration: 0.050 ms",
+		"2024-01-02 10:00:00.002 UTC [43] LOG:  statement: SELECT *",
+		"\tFROM items",
+		"2024-01-02 10:00:00.003 UTC [44] LOG:  duration: 0.020 ms  parse <unnamed>: SELECT $1",
+		"2024-01-02 10:00:00.004 UTC [44] LOG:  duration: 0.010 ms  bind <unnamed>: SELECT $1",
+		"2024-01-02 10:00:00.004 UTC [44] DETAIL:  parameters: $1 = '1'",
+		"2024-01-02 10:00:00.005 UTC [44] LOG:  execute <unnamed>: SELECT $1",
+		"2024-01-02 10:00:00.005 UTC [44] DETAIL:  parameters: $1 = '1'",
+		"2024-01-02 10:00:00.006 UTC [43] LOG:  duration: 1.500 ms",
+		"2024-01-02 10:00:00.007 UTC [44] LOG:  duration: 0.030 ms",
+		"2024-01-02 10:00:00.008 UTC [43] LOG:  statement: SELECT * FROM missing",
+		"2024-01-02 10:00:00.008 UTC [43] ERROR:  relation \"missing\" does not exist at character 15",
+		"2024-01-02 10:00:00.008 UTC [43] STATEMENT:  SELECT * FROM missing",
+		"2024-01-02 10:00:00.009 UTC [44] ERROR:  syntax error at or near \"SELEC\" at character 1",
+		"2024-01-02 10:00:00.009 UTC [44] STATEMENT:  SELEC 1",
+		"2024-01-02 10:00:00.010 UTC [42] LOG:  statement: SELECT 'testsetup-capture-marker:end'",
+	} {
+		log.parseLine(line)
+	}
+
+	assert.Equal(t, 0, log.markers["start"])
+	assert.Equal(t, 4, log.markers["end"])
+	assert.Equal(t, []Query{
+		{Statement: "SELECT *\nFROM items", Duration: 1500 * time.Microsecond},
+		{Statement: "SELECT $1", Parameters: "$1 = '1'", Duration: 30 * time.Microsecond},
+		{Statement: "SELECT * FROM missing", Error: "relation \"missing\" does not exist at character 15"},
+		{Statement: "SELEC 1", Error: "syntax error at or near \"SELEC\" at character 1"},
+	}, log.between(log.markers["start"], log.markers["end"]))
+}
@@ -0,0 +1,22 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresCommand(t *testing.T) {
+	assert.Equal(t,
+		[]string{"postgres", "-c", "ssl=on", "-c", "wal_level=logical"},
+		postgresCommand(map[string]string{"wal_level": "logical", "ssl": "on"}))
+}
+
+func TestPostgresReplicatedRejectsTLS(t *testing.T) {
+	replicated := WithPostgresReplicated(PostgresReplicatedOpts{
+		Primary:              PostgresContainerOpts{ContainerName: "primary", TLS: &PostgresTLSOpts{}},
+		ReplicaExternalPorts: []string{"5440"},
+	})
+	assert.ErrorIs(t, replicated.Start(docker.AuthConfiguration{}, nil), ErrTLSUnsupported)
+}
@@ -0,0 +1,227 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+	kafkaClient "github.com/segmentio/kafka-go"
+)
+
+var ErrRedpandaUnsupported = errors.New("not supported by the redpanda container")
+var ErrSchemaRegistryPortMissing = errors.New("schemas require SchemaRegistryExternalPort")
+
+type redpanda struct {
+	hostName        string
+	Opts            testsetup.DockerContainerOpts
+	r               *dockertest.Resource
+	pool            *dockertest.Pool
+	opts            RedpandaOpts
+	topics          []TopicSpec
+	externalAddress string
+	schemaIDs       map[string]int
+	kafkaAccess
+	// invalid is returned on start, e.g. if security was requested through KafkaOpts.
+	invalid error
+}
+
+// RedpandaContainer is a KafkaContainer running redpanda with its built-in schema registry.
+type RedpandaContainer interface {
+	KafkaContainer
+	// SchemaRegistryURL returns the address of the schema registry reachable from the outside.
+	SchemaRegistryURL() string
+	// RegisterSchema registers a new version of the schema and returns its id.
+	RegisterSchema(ctx context.Context, schema RegistrySchema) (int, error)
+	// SchemaIDs returns the id of every schema registered on start by subject.
+	SchemaIDs() map[string]int
+}
+
+type RedpandaOpts struct {
+	ContainerName string
+	// ContainerNamePort accepts connections in combination with ContainerName.
+	ContainerNamePort string
+	// ExternalHostName is the only accepted DNS name if you want to connect from the outside.
+	// For DinD environments this is "docker", for local testing it is "localhost". If empty
+	// "docker" will be set if running in a CI environment and "localhost" otherwise.
+	ExternalHostName string
+	// ExternalPort accepts kafka connections in combination with ExternalHostName.
+	ExternalPort string
+	// SchemaRegistryExternalPort publishes the schema registry. Within the network it listens on port 8081.
+	SchemaRegistryExternalPort string
+	NetworkID                  string
+	// Topics are created on start in addition to the bare topic names, which use the defaults of TopicSpec.
+	Topics []TopicSpec
+	// Schemas are registered in order once redpanda is healthy. Requires SchemaRegistryExternalPort.
+	Schemas []RegistrySchema
+}
+
+// WithRedpanda returns a Container in order to spawn redpanda in dev-container mode, a single kafka compatible
+// broker that starts within seconds and comes with a schema registry. It provides the same accessors and topic
+// creation as WithKafka, but neither SASL, TLS nor ACLs.
+func WithRedpanda(opts RedpandaOpts, topics ...string) RedpandaContainer {
+	opts.ExternalHostName = validateHost(opts.ExternalHostName)
+	r := &redpanda{
+		opts:            opts,
+		topics:          topicSpecs(1, opts.Topics, topics...),
+		externalAddress: opts.ExternalHostName + ":" + opts.ExternalPort,
+		Opts: testsetup.DockerContainerOpts{
+			Repository:    "redpandadata/redpanda",
+			ContainerName: opts.ContainerName,
+			Tag:           "v24.1.7",
+			PortBinding:   map[string]string{opts.ExternalPort: "9092"},
+			Commands: []string{
+				"redpanda", "start", "--mode", "dev-container", "--smp", "1",
+				"--kafka-addr", "external://0.0.0.0:9092,internal://0.0.0.0:" + opts.ContainerNamePort,
+				"--advertise-kafka-addr", "external://" + opts.ExternalHostName + ":" + opts.ExternalPort +
+					",internal://" + opts.ContainerName + ":" + opts.ContainerNamePort,
+				"--schema-registry-addr", "0.0.0.0:8081",
+			},
+			ExpireTime: 5,
+			NetworkID:  opts.NetworkID,
+		},
+	}
+	r.kafkaAccess = kafkaAccess{
+		security:  newKafkaSecurity(nil, opts.ExternalHostName, opts.ContainerName),
+		addresses: r.Brokers,
+		admin:     r.adminClient,
+	}
+	if len(opts.Schemas) > 0 && opts.SchemaRegistryExternalPort == "" {
+		r.invalid = fmt.Errorf("%w: %d schemas are set", ErrSchemaRegistryPortMissing, len(opts.Schemas))
+	}
+	if opts.SchemaRegistryExternalPort != "" {
+		r.Opts.PortBinding[opts.SchemaRegistryExternalPort] = "8081"
+	}
+	r.Opts.HealthCheck = func(pool *dockertest.Pool, _ *dockertest.Resource) error {
+		dialer, err := r.Dialer("")
+		if err != nil {
+			return err
+		}
+		if err := kafkaHealthCheck(pool, dialer, r.externalAddress); err != nil {
+			return err
+		}
+		if opts.SchemaRegistryExternalPort == "" {
+			return nil
+		}
+		return pool.Retry(func() error {
+			return schemaRegistryRequest(context.Background(), http.MethodGet, r.SchemaRegistryURL()+"/subjects", nil, nil)
+		})
+	}
+	return r
+}
+
+// withRedpandaFromKafka returns the redpanda counterpart of WithKafka, see KafkaOpts.Redpanda.
+func withRedpandaFromKafka(opts KafkaOpts, topics ...string) RedpandaContainer {
+	r := WithRedpanda(RedpandaOpts{
+		ContainerName:     opts.ContainerName,
+		ContainerNamePort: opts.ContainerNamePort,
+		ExternalHostName:  opts.ExternalHostName,
+		ExternalPort:      opts.ExternalPort,
+		NetworkID:         opts.NetworkID,
+		Topics:            opts.Topics,
+	}, topics...).(*redpanda)
+	if opts.Security != nil {
+		r.invalid = fmt.Errorf("%w: security options are set", ErrRedpandaUnsupported)
+	}
+	return r
+}
+
+func (r *redpanda) GetHostname() string {
+	return r.hostName
+}
+
+func (r *redpanda) GetPorts() []int {
+	var ports []int
+	for external := range r.Opts.PortBinding {
+		port, _ := strconv.Atoi(external)
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func (r *redpanda) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if r.invalid != nil {
+		return r.invalid
+	}
+	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, r.Opts)
+	if err != nil {
+		return err
+	}
+	r.hostName = *hostname
+	r.r = resource
+	r.pool = pool
+	if err := r.provision(context.Background()); err != nil {
+		_ = resource.Close()
+		return err
+	}
+	return nil
+}
+
+// provision creates the topics and registers the schemas.
+func (r *redpanda) provision(ctx context.Context) error {
+	if len(r.topics) > 0 {
+		client, err := r.adminClient()
+		if err != nil {
+			return err
+		}
+		if err := createTopics(ctx, r.pool, client, r.topics); err != nil {
+			return err
+		}
+	}
+	r.schemaIDs = map[string]int{}
+	for _, schema := range r.opts.Schemas {
+		id, err := r.RegisterSchema(ctx, schema)
+		if err != nil {
+			return err
+		}
+		r.schemaIDs[schema.Subject] = id
+	}
+	return nil
+}
+
+func (r *redpanda) adminClient() (*kafkaClient.Client, error) {
+	transport, err := r.security.adminTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaClient.Client{Addr: kafkaClient.TCP(r.externalAddress), Timeout: 10 * time.Second, Transport: transport}, nil
+}
+
+func (r *redpanda) SchemaRegistryURL() string {
+	return "http://" + r.opts.ExternalHostName + ":" + r.opts.SchemaRegistryExternalPort
+}
+
+func (r *redpanda) RegisterSchema(ctx context.Context, schema RegistrySchema) (int, error) {
+	return registerSchema(ctx, r.SchemaRegistryURL(), schema)
+}
+
+func (r *redpanda) SchemaIDs() map[string]int {
+	return r.schemaIDs
+}
+
+func (r *redpanda) Brokers() []string {
+	return []string{r.externalAddress}
+}
+
+func (r *redpanda) GrantACL(context.Context, ...KafkaACL) error {
+	return ErrRedpandaUnsupported
+}
+
+func (r *redpanda) RevokeACL(context.Context, ...KafkaACL) error {
+	return ErrRedpandaUnsupported
+}
+
+func (r *redpanda) Stop() error {
+	r.recorders.finish()
+	r.security.closeAdminTransport()
+	return r.r.Close()
+}
+
+func (r *redpanda) SetLabel(label map[string]string) {
+	r.Opts.Labels = label
+}
@@ -0,0 +1,60 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRedpanda(t *testing.T) {
+	r := WithRedpanda(RedpandaOpts{
+		ContainerName:              "panda",
+		ContainerNamePort:          "9091",
+		ExternalHostName:           "localhost",
+		ExternalPort:               "19092",
+		SchemaRegistryExternalPort: "18081",
+	}, "orders").(*redpanda)
+
+	assert.Equal(t, map[string]string{"19092": "9092", "18081": "8081"}, r.Opts.PortBinding)
+	assert.ElementsMatch(t, []int{19092, 18081}, r.GetPorts())
+	assert.Contains(t, r.Opts.Commands, "external://localhost:19092,internal://panda:9091")
+	assert.Equal(t, []string{"localhost:19092"}, r.Brokers())
+	assert.Equal(t, "http://localhost:18081", r.SchemaRegistryURL())
+	assert.Equal(t, []TopicSpec{{Name: "orders", Partitions: 1, ReplicationFactor: 1}}, r.topics)
+	assert.Nil(t, r.Certificates())
+
+	config, err := r.ClientConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:19092", config["bootstrap.servers"])
+	assert.ErrorIs(t, r.GrantACL(context.Background()), ErrRedpandaUnsupported)
+}
+
+func TestWithRedpandaSchemasRequirePort(t *testing.T) {
+	r := WithRedpanda(RedpandaOpts{
+		ContainerName: "panda",
+		ExternalPort:  "19092",
+		Schemas:       []RegistrySchema{{Subject: "orders-value", File: "testdata/order.avsc"}},
+	})
+	assert.ErrorIs(t, r.Start(docker.AuthConfiguration{}, nil), ErrSchemaRegistryPortMissing)
+}
+
+func TestWithKafkaRedpanda(t *testing.T) {
+	opts := KafkaOpts{
+		ContainerName:     "panda",
+		ContainerNamePort: "9091",
+		ExternalHostName:  "localhost",
+		ExternalPort:      "19092",
+		Redpanda:          true,
+	}
+	r, ok := WithKafka(opts, "orders").(*redpanda)
+	require.True(t, ok)
+	assert.Equal(t, "redpandadata/redpanda", r.Opts.Repository)
+	assert.Equal(t, map[string]string{"19092": "9092"}, r.Opts.PortBinding)
+
+	opts.Security = &KafkaSecurityOpts{SASL: KafkaSASLPlain}
+	err := WithKafka(opts).Start(docker.AuthConfiguration{}, nil)
+	assert.ErrorIs(t, err, ErrRedpandaUnsupported)
+}
@@ -0,0 +1,238 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// SchemaType is the format of a schema in the schema registry.
+type SchemaType string
+
+const (
+	SchemaTypeAvro     SchemaType = "AVRO"
+	SchemaTypeProtobuf SchemaType = "PROTOBUF"
+	SchemaTypeJSON     SchemaType = "JSON"
+)
+
+// SchemaCompatibility is a compatibility level of the schema registry.
+type SchemaCompatibility string
+
+const (
+	SchemaCompatibilityNone               SchemaCompatibility = "NONE"
+	SchemaCompatibilityBackward           SchemaCompatibility = "BACKWARD"
+	SchemaCompatibilityBackwardTransitive SchemaCompatibility = "BACKWARD_TRANSITIVE"
+	SchemaCompatibilityForward            SchemaCompatibility = "FORWARD"
+	SchemaCompatibilityForwardTransitive  SchemaCompatibility = "FORWARD_TRANSITIVE"
+	SchemaCompatibilityFull               SchemaCompatibility = "FULL"
+	SchemaCompatibilityFullTransitive     SchemaCompatibility = "FULL_TRANSITIVE"
+)
+
+// schemaRegistryContentType is the content type the registry expects for requests.
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+func schemaRegistryRequest(ctx context.Context, method string, endpoint string, body any, result any) error {
+	return requestJSON(ctx, method, endpoint, schemaRegistryContentType, body, result)
+}
+
+type schemaRegistry struct {
+	hostName  string
+	Port      int
+	Opts      testsetup.DockerContainerOpts
+	r         *dockertest.Resource
+	opts      SchemaRegistryOpts
+	kafka     testsetup.Container
+	schemaIDs map[string]int
+}
+
+// SchemaRegistryContainer is a Container running the confluent schema registry.
+type SchemaRegistryContainer interface {
+	testsetup.Container
+	// URL returns the address of the REST API reachable from the outside.
+	URL() string
+	// RegisterSchema registers a new version of the schema and returns its id.
+	RegisterSchema(ctx context.Context, schema RegistrySchema) (int, error)
+	// SetCompatibility sets the compatibility level of the subject or the global level if subject is empty.
+	SetCompatibility(ctx context.Context, subject string, compatibility SchemaCompatibility) error
+	// SchemaIDs returns the id of every schema registered on start by subject.
+	SchemaIDs() map[string]int
+}
+
+type SchemaRegistryOpts struct {
+	ContainerName string
+	NetworkID     string
+	// KafkaContainerNamePort is the ContainerNamePort of the kafka container.
+	KafkaContainerNamePort string
+	// ExternalHost is the hostname the container is reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost string
+	ExternalPort string
+	// Compatibility is the global compatibility level. Defaults to the registry default BACKWARD.
+	Compatibility SchemaCompatibility
+	// Schemas are registered in order once the registry is healthy.
+	Schemas []RegistrySchema
+}
+
+// RegistrySchema is a schema read from a file and registered under a subject.
+type RegistrySchema struct {
+	// Subject is usually "<topic>-key" or "<topic>-value".
+	Subject string
+	// File contains the schema definition. Schema references are not supported.
+	File string
+	// Type defaults to SchemaTypeAvro.
+	Type SchemaType
+	// Compatibility sets the level of the subject before the schema is registered.
+	Compatibility SchemaCompatibility
+}
+
+// WithSchemaRegistry returns a Container in order to spawn a schema registry storing its schemas in kafka.
+// The kafka container is reached by its hostname within the network, so it has to be
+// started before the registry, i.e. passed before it to testsetup.NewTestSetup.
+func WithSchemaRegistry(opts SchemaRegistryOpts, kafka testsetup.Container) SchemaRegistryContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	port, _ := strconv.Atoi(opts.ExternalPort)
+	s := &schemaRegistry{
+		Port:  port,
+		opts:  opts,
+		kafka: kafka,
+		Opts: testsetup.DockerContainerOpts{
+			ContainerName: opts.ContainerName,
+			Repository:    "confluentinc/cp-schema-registry",
+			Tag:           "7.6.1",
+			PortBinding:   map[string]string{opts.ExternalPort: "8081"},
+			Env: map[string]string{
+				"SCHEMA_REGISTRY_HOST_NAME": opts.ContainerName,
+				"SCHEMA_REGISTRY_LISTENERS": "http://0.0.0.0:8081",
+				// A single broker is not able to satisfy the default of three replicas.
+				"SCHEMA_REGISTRY_KAFKASTORE_TOPIC_REPLICATION_FACTOR": "1",
+			},
+			ExpireTime: 5,
+			NetworkID:  opts.NetworkID,
+		},
+	}
+	s.Opts.HealthCheck = func(pool *dockertest.Pool, _ *dockertest.Resource) error {
+		if err := pool.Retry(func() error {
+			return schemaRegistryRequest(context.Background(), http.MethodGet, s.URL()+"/subjects", nil, nil)
+		}); err != nil {
+			return err
+		}
+		return nil
+	}
+	return s
+}
+
+func (s *schemaRegistry) URL() string {
+	return "http://" + s.opts.ExternalHost + ":" + s.opts.ExternalPort
+}
+
+func (s *schemaRegistry) SchemaIDs() map[string]int {
+	return s.schemaIDs
+}
+
+func (s *schemaRegistry) RegisterSchema(ctx context.Context, schema RegistrySchema) (int, error) {
+	return registerSchema(ctx, s.URL(), schema)
+}
+
+func (s *schemaRegistry) SetCompatibility(ctx context.Context, subject string, compatibility SchemaCompatibility) error {
+	return setSchemaCompatibility(ctx, s.URL(), subject, compatibility)
+}
+
+func (s *schemaRegistry) GetHostname() string {
+	return s.hostName
+}
+
+func (s *schemaRegistry) GetPorts() []int {
+	return []int{s.Port}
+}
+
+func (s *schemaRegistry) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if s.opts.KafkaContainerNamePort == "" {
+		return fmt.Errorf("%w: the schema registry needs KafkaContainerNamePort", ErrInvalidPort)
+	}
+	s.Opts.Env["SCHEMA_REGISTRY_KAFKASTORE_BOOTSTRAP_SERVERS"] = "PLAINTEXT://" + s.kafka.GetHostname() + ":" +
+		s.opts.KafkaContainerNamePort
+	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, s.Opts)
+	if err != nil {
+		return err
+	}
+	s.hostName = *hostname
+	s.r = resource
+	if err := s.provision(context.Background()); err != nil {
+		_ = resource.Close()
+		return err
+	}
+	return nil
+}
+
+// provision sets the global compatibility level and registers the schemas.
+func (s *schemaRegistry) provision(ctx context.Context) error {
+	if s.opts.Compatibility != "" {
+		if err := s.SetCompatibility(ctx, "", s.opts.Compatibility); err != nil {
+			return err
+		}
+	}
+	s.schemaIDs = map[string]int{}
+	for _, schema := range s.opts.Schemas {
+		id, err := s.RegisterSchema(ctx, schema)
+		if err != nil {
+			return err
+		}
+		s.schemaIDs[schema.Subject] = id
+	}
+	return nil
+}
+
+func (s *schemaRegistry) Stop() error {
+	return s.r.Close()
+}
+
+func (s *schemaRegistry) SetLabel(label map[string]string) {
+	s.Opts.Labels = label
+}
+
+// registerSchema sets the compatibility level of the subject if given and registers the schema.
+func registerSchema(ctx context.Context, baseURL string, schema RegistrySchema) (int, error) {
+	definition, err := os.ReadFile(schema.File)
+	if err != nil {
+		return 0, err
+	}
+	if schema.Compatibility != "" {
+		if err := setSchemaCompatibility(ctx, baseURL, schema.Subject, schema.Compatibility); err != nil {
+			return 0, err
+		}
+	}
+	body := map[string]string{"schema": string(definition)}
+	// The registry treats schemas without a type as avro and older versions reject the field.
+	if schema.Type != "" && schema.Type != SchemaTypeAvro {
+		body["schemaType"] = string(schema.Type)
+	}
+	var registered struct {
+		ID int `json:"id"`
+	}
+	if err := schemaRegistryRequest(ctx, http.MethodPost,
+		baseURL+"/subjects/"+url.PathEscape(schema.Subject)+"/versions", body, &registered); err != nil {
+		return 0, fmt.Errorf("unable to register %s for %s: %w", schema.File, schema.Subject, err)
+	}
+	return registered.ID, nil
+}
+
+func setSchemaCompatibility(ctx context.Context, baseURL string, subject string, compatibility SchemaCompatibility) error {
+	path := "/config"
+	if subject != "" {
+		path += "/" + url.PathEscape(subject)
+	}
+	if err := schemaRegistryRequest(ctx, http.MethodPut, baseURL+path,
+		map[string]string{"compatibility": string(compatibility)}, nil); err != nil {
+		return fmt.Errorf("unable to set compatibility %s: %w", compatibility, err)
+	}
+	return nil
+}
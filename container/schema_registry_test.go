@@ -0,0 +1,64 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterSchema(t *testing.T) {
+	var requests []string
+	var bodies []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		assert.Equal(t, schemaRegistryContentType, r.Header.Get("Content-Type"))
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		_, _ = w.Write([]byte(`{"id": 7}`))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "order.proto")
+	require.NoError(t, os.WriteFile(file, []byte(`syntax = "proto3"; message Order {}`), 0600))
+	id, err := registerSchema(context.Background(), server.URL, RegistrySchema{
+		Subject:       "orders/v1-value",
+		File:          file,
+		Type:          SchemaTypeProtobuf,
+		Compatibility: SchemaCompatibilityFull,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 7, id)
+	assert.Equal(t, []string{"PUT /config/orders%2Fv1-value", "POST /subjects/orders%2Fv1-value/versions"}, requests)
+	assert.Equal(t, []map[string]string{
+		{"compatibility": "FULL"},
+		{"schema": `syntax = "proto3"; message Order {}`, "schemaType": "PROTOBUF"},
+	}, bodies)
+}
+
+func TestRegisterSchemaError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error_code": 409, "message": "incompatible"}`))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "order.avsc")
+	require.NoError(t, os.WriteFile(file, []byte(`{"type": "string"}`), 0600))
+	_, err := registerSchema(context.Background(), server.URL, RegistrySchema{Subject: "orders-value", File: file})
+	assert.ErrorContains(t, err, "incompatible")
+}
+
+func TestSchemaRegistryRequiresKafkaPort(t *testing.T) {
+	kafka := WithKafka(KafkaOpts{ContainerName: "kafka"})
+	registry := WithSchemaRegistry(SchemaRegistryOpts{ContainerName: "registry", ExternalPort: "8081"}, kafka)
+	assert.ErrorIs(t, registry.Start(docker.AuthConfiguration{}, nil), ErrInvalidPort)
+}
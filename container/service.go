@@ -0,0 +1,48 @@
+package container
+
+import (
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// service is a plain docker container, mostly used as part of a composite container.
+type service struct {
+	hostName string
+	Opts     testsetup.DockerContainerOpts
+	r        *dockertest.Resource
+}
+
+// noHealthCheck is used for containers that are checked through another container.
+func noHealthCheck(*dockertest.Pool, *dockertest.Resource) error {
+	return nil
+}
+
+func (s *service) GetHostname() string {
+	return s.hostName
+}
+
+func (s *service) GetPorts() []int {
+	return []int{}
+}
+
+func (s *service) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	resource, hostname, err := testsetup.RunDockerContainer(auth, pool, s.Opts)
+	if err != nil {
+		return err
+	}
+	s.hostName = *hostname
+	s.r = resource
+	return nil
+}
+
+func (s *service) Stop() error {
+	if s.r == nil {
+		return nil
+	}
+	return s.r.Close()
+}
+
+func (s *service) SetLabel(label map[string]string) {
+	s.Opts.Labels = label
+}
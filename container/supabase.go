@@ -0,0 +1,398 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/lib/pq"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+// supabaseKongConfig is the declarative kong configuration routing the APIs like the supabase platform.
+// Placeholders: anon key, service_role key, auth host, rest host, storage host.
+const supabaseKongConfig = `_format_version: "2.1"
+_transform: true
+consumers:
+  - username: anon
+    keyauth_credentials:
+      - key: %[1]s
+  - username: service_role
+    keyauth_credentials:
+      - key: %[2]s
+acls:
+  - consumer: anon
+    group: anon
+  - consumer: service_role
+    group: admin
+services:
+  - name: auth-v1-open
+    url: http://%[3]s:9999/verify
+    routes:
+      - name: auth-v1-open
+        strip_path: true
+        paths: [/auth/v1/verify]
+    plugins:
+      - name: cors
+  - name: auth-v1-open-callback
+    url: http://%[3]s:9999/callback
+    routes:
+      - name: auth-v1-open-callback
+        strip_path: true
+        paths: [/auth/v1/callback]
+    plugins:
+      - name: cors
+  - name: auth-v1-open-authorize
+    url: http://%[3]s:9999/authorize
+    routes:
+      - name: auth-v1-open-authorize
+        strip_path: true
+        paths: [/auth/v1/authorize]
+    plugins:
+      - name: cors
+  - name: auth-v1
+    url: http://%[3]s:9999/
+    routes:
+      - name: auth-v1-all
+        strip_path: true
+        paths: [/auth/v1/]
+    plugins:
+      - name: cors
+      - name: key-auth
+        config:
+          hide_credentials: false
+      - name: acl
+        config:
+          hide_groups_header: true
+          allow: [admin, anon]
+  - name: rest-v1
+    url: http://%[4]s:3000/
+    routes:
+      - name: rest-v1-all
+        strip_path: true
+        paths: [/rest/v1/]
+    plugins:
+      - name: cors
+      - name: key-auth
+        config:
+          hide_credentials: true
+      - name: acl
+        config:
+          hide_groups_header: true
+          allow: [admin, anon]
+  - name: storage-v1
+    url: http://%[5]s:5000/
+    routes:
+      - name: storage-v1-all
+        strip_path: true
+        paths: [/storage/v1/]
+    plugins:
+      - name: cors
+`
+
+type supabase struct {
+	db       *supabasePostgres
+	auth     *service
+	rest     *service
+	storage  *service
+	kong     *service
+	opts     SupabaseOpts
+	keys     SupabaseKeys
+	apiPort  int
+	setupErr error
+}
+
+// SupabaseContainer is a Container running the supabase stack that provides the API URL and keys.
+type SupabaseContainer interface {
+	SupabasePostgresContainer
+	// APIURL returns the URL of the API gateway, e.g. http://localhost:8000.
+	// Auth is served below /auth/v1, PostgREST below /rest/v1 and storage below /storage/v1.
+	APIURL() string
+	// Keys returns the JWT secret and the API keys.
+	Keys() SupabaseKeys
+	// CreateUserWithGoTrue creates a user through the GoTrue admin API and returns its id.
+	CreateUserWithGoTrue(ctx context.Context, user SupabaseUser) (string, error)
+	// MintJWT signs a token for an arbitrary role and claims with the JWT secret.
+	MintJWT(role string, claims map[string]any) (string, error)
+	// MintUserJWT signs a token for the user like GoTrue does on sign in.
+	MintUserJWT(userID string, claims map[string]any) (string, error)
+}
+
+// SupabaseKeys holds the generated JWT secret and the keys signed with it.
+type SupabaseKeys struct {
+	JWTSecret      string
+	AnonKey        string
+	ServiceRoleKey string
+}
+
+type SupabaseOpts struct {
+	// ContainerName is the prefix of all containers: <name>-db, <name>-auth, <name>-rest, <name>-storage and <name>-kong.
+	ContainerName string
+	NetworkID     string
+	DBPass        string
+	// ExternalHost is the hostname the containers are reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost   string
+	DBExternalPort string
+	// APIExternalPort is the port of the API gateway.
+	APIExternalPort string
+	// JWTSecret signs and verifies all tokens. A random secret is generated if empty.
+	JWTSecret string
+}
+
+// WithSupabase returns a Container in order to spawn the supabase stack: the database, GoTrue (auth),
+// PostgREST, the Storage API and Kong as API gateway in front of them.
+func WithSupabase(opts SupabaseOpts) SupabaseContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	s := &supabase{}
+	s.apiPort, _ = strconv.Atoi(opts.APIExternalPort)
+	if opts.JWTSecret == "" {
+		opts.JWTSecret, s.setupErr = randomSecret(32)
+	}
+	s.opts = opts
+	s.keys.JWTSecret = opts.JWTSecret
+	if s.setupErr == nil {
+		s.keys.AnonKey, s.setupErr = supabaseAPIKey(opts.JWTSecret, "anon")
+	}
+	if s.setupErr == nil {
+		s.keys.ServiceRoleKey, s.setupErr = supabaseAPIKey(opts.JWTSecret, "service_role")
+	}
+
+	db := WithSupabasePostgres(SupabasePostgresContainerOpts{
+		ContainerName:  opts.ContainerName + "-db",
+		NetworkID:      opts.NetworkID,
+		DBName:         "postgres",
+		DBPass:         opts.DBPass,
+		ExternalDBHost: opts.ExternalHost,
+		DBExternalPort: opts.DBExternalPort,
+		DBInternalPort: "5432",
+	}).(*supabasePostgres)
+	s.db = db
+	dbURL := func(user string) string {
+		return (&url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(user, opts.DBPass),
+			Host:   db.opts.ContainerName + ":5432",
+			Path:   "/postgres",
+		}).String()
+	}
+	apiURL := s.APIURL()
+
+	s.auth = &service{Opts: testsetup.DockerContainerOpts{
+		ContainerName: opts.ContainerName + "-auth",
+		Repository:    "supabase/gotrue",
+		Tag:           "v2.158.1",
+		NetworkID:     opts.NetworkID,
+		Env: map[string]string{
+			"GOTRUE_API_HOST":                         "0.0.0.0",
+			"GOTRUE_API_PORT":                         "9999",
+			"API_EXTERNAL_URL":                        apiURL,
+			"GOTRUE_DB_DRIVER":                        "postgres",
+			"GOTRUE_DB_DATABASE_URL":                  dbURL("supabase_auth_admin"),
+			"GOTRUE_SITE_URL":                         apiURL,
+			"GOTRUE_DISABLE_SIGNUP":                   "false",
+			"GOTRUE_JWT_ADMIN_ROLES":                  "service_role",
+			"GOTRUE_JWT_AUD":                          "authenticated",
+			"GOTRUE_JWT_DEFAULT_GROUP_NAME":           "authenticated",
+			"GOTRUE_JWT_EXP":                          "3600",
+			"GOTRUE_JWT_SECRET":                       opts.JWTSecret,
+			"GOTRUE_EXTERNAL_EMAIL_ENABLED":           "true",
+			"GOTRUE_MAILER_AUTOCONFIRM":               "true",
+			"GOTRUE_EXTERNAL_PHONE_ENABLED":           "false",
+			"GOTRUE_SMS_AUTOCONFIRM":                  "false",
+			"GOTRUE_EXTERNAL_ANONYMOUS_USERS_ENABLED": "false",
+		},
+		ExpireTime:  5,
+		HealthCheck: noHealthCheck,
+	}}
+	s.rest = &service{Opts: testsetup.DockerContainerOpts{
+		ContainerName: opts.ContainerName + "-rest",
+		Repository:    "postgrest/postgrest",
+		Tag:           "v12.2.0",
+		NetworkID:     opts.NetworkID,
+		Env: map[string]string{
+			"PGRST_DB_URI":                  dbURL("authenticator"),
+			"PGRST_DB_SCHEMAS":              "public,storage,graphql_public",
+			"PGRST_DB_ANON_ROLE":            "anon",
+			"PGRST_JWT_SECRET":              opts.JWTSecret,
+			"PGRST_DB_USE_LEGACY_GUCS":      "false",
+			"PGRST_APP_SETTINGS_JWT_SECRET": opts.JWTSecret,
+			"PGRST_APP_SETTINGS_JWT_EXP":    "3600",
+		},
+		ExpireTime:  5,
+		HealthCheck: noHealthCheck,
+	}}
+	s.storage = &service{Opts: testsetup.DockerContainerOpts{
+		ContainerName: opts.ContainerName + "-storage",
+		Repository:    "supabase/storage-api",
+		Tag:           "v1.11.13",
+		NetworkID:     opts.NetworkID,
+		Env: map[string]string{
+			"ANON_KEY":                    s.keys.AnonKey,
+			"SERVICE_KEY":                 s.keys.ServiceRoleKey,
+			"POSTGREST_URL":               "http://" + opts.ContainerName + "-rest:3000",
+			"PGRST_JWT_SECRET":            opts.JWTSecret,
+			"DATABASE_URL":                dbURL("supabase_storage_admin"),
+			"FILE_SIZE_LIMIT":             "52428800",
+			"STORAGE_BACKEND":             "file",
+			"FILE_STORAGE_BACKEND_PATH":   "/var/lib/storage",
+			"TENANT_ID":                   "stub",
+			"REGION":                      "stub",
+			"GLOBAL_S3_BUCKET":            "stub",
+			"ENABLE_IMAGE_TRANSFORMATION": "false",
+		},
+		ExpireTime:  5,
+		HealthCheck: noHealthCheck,
+	}}
+	s.kong = &service{Opts: testsetup.DockerContainerOpts{
+		ContainerName: opts.ContainerName + "-kong",
+		Repository:    "kong",
+		Tag:           "2.8.1",
+		NetworkID:     opts.NetworkID,
+		PortBinding:   map[string]string{opts.APIExternalPort: "8000"},
+		Env: map[string]string{
+			"KONG_DATABASE":                      "off",
+			"KONG_DECLARATIVE_CONFIG":            "/tmp/kong.yml",
+			"KONG_DNS_ORDER":                     "LAST,A,CNAME",
+			"KONG_PLUGINS":                       "request-transformer,cors,key-auth,acl",
+			"KONG_NGINX_PROXY_PROXY_BUFFER_SIZE": "160k",
+			"KONG_NGINX_PROXY_PROXY_BUFFERS":     "64 160k",
+			"SUPABASE_KONG_CONFIG": fmt.Sprintf(supabaseKongConfig,
+				s.keys.AnonKey,
+				s.keys.ServiceRoleKey,
+				opts.ContainerName+"-auth",
+				opts.ContainerName+"-rest",
+				opts.ContainerName+"-storage"),
+		},
+		EntryPoint: []string{"/bin/sh", "-c",
+			`printf '%s' "$SUPABASE_KONG_CONFIG" > /tmp/kong.yml && exec /docker-entrypoint.sh kong docker-start`},
+		ExpireTime:  5,
+		HealthCheck: s.healthCheck,
+	}}
+	return s
+}
+
+// healthCheck waits until all APIs respond through the gateway.
+func (s *supabase) healthCheck(pool *dockertest.Pool, _ *dockertest.Resource) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return pool.Retry(func() error {
+		for _, path := range []string{"/auth/v1/health", "/rest/v1/", "/storage/v1/status"} {
+			req, err := http.NewRequest(http.MethodGet, s.APIURL()+path, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("apikey", s.keys.AnonKey)
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s responded with %s", path, resp.Status)
+			}
+		}
+		return nil
+	})
+}
+
+// setRolePasswords sets the passwords of the roles the services connect with, like the supabase CLI does.
+func (s *supabase) setRolePasswords(ctx context.Context) error {
+	statement := ""
+	for _, role := range []string{"authenticator", "supabase_auth_admin", "supabase_storage_admin"} {
+		statement += "ALTER USER " + role + " WITH PASSWORD " + pq.QuoteLiteral(s.opts.DBPass) + "; "
+	}
+	statement += `ALTER DATABASE postgres SET "app.settings.jwt_secret" TO ` + pq.QuoteLiteral(s.opts.JWTSecret) + ";"
+	return testsetup.ExecInContainer(ctx, s.db.pool, s.db.r, testsetup.ExecOpts{
+		Cmd: []string{"psql", "-h", "127.0.0.1", "-U", "supabase_admin", "-d", "postgres",
+			"-v", "ON_ERROR_STOP=1", "-c", statement},
+		Env: map[string]string{"PGPASSWORD": s.opts.DBPass},
+	})
+}
+
+func (s *supabase) APIURL() string {
+	return "http://" + s.opts.ExternalHost + ":" + s.opts.APIExternalPort
+}
+
+func (s *supabase) Keys() SupabaseKeys {
+	return s.keys
+}
+
+func (s *supabase) DSN() string {
+	return s.db.DSN()
+}
+
+func (s *supabase) RecordChanges(t testing.TB) *ChangeRecorder {
+	t.Helper()
+	return s.db.RecordChanges(t)
+}
+
+func (s *supabase) Dump(ctx context.Context, w io.Writer, opts DumpOpts) error {
+	return s.db.Dump(ctx, w, opts)
+}
+
+func (s *supabase) Restore(ctx context.Context, r io.Reader, opts DumpOpts) error {
+	return s.db.Restore(ctx, r, opts)
+}
+
+func (s *supabase) AssertSchemaGolden(t testing.TB, goldenFile string) bool {
+	t.Helper()
+	return s.db.AssertSchemaGolden(t, goldenFile)
+}
+
+func (s *supabase) GetHostname() string {
+	return s.kong.GetHostname()
+}
+
+func (s *supabase) GetPorts() []int {
+	return append(s.db.GetPorts(), s.apiPort)
+}
+
+func (s *supabase) Size() int {
+	return 5
+}
+
+func (s *supabase) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if s.setupErr != nil {
+		return s.setupErr
+	}
+	if err := s.db.Start(auth, pool); err != nil {
+		return err
+	}
+	if err := s.setRolePasswords(context.Background()); err != nil {
+		return err
+	}
+	for _, c := range []*service{s.auth, s.rest, s.storage, s.kong} {
+		if err := c.Start(auth, pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *supabase) Stop() error {
+	var errs []error
+	for _, c := range []*service{s.kong, s.storage, s.rest, s.auth} {
+		errs = append(errs, c.Stop())
+	}
+	if s.db.r != nil {
+		errs = append(errs, s.db.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *supabase) SetLabel(label map[string]string) {
+	s.db.SetLabel(label)
+	for _, c := range []*service{s.auth, s.rest, s.storage, s.kong} {
+		c.SetLabel(label)
+	}
+}
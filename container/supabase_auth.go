@@ -0,0 +1,204 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// SupabaseUser is a user of supabase auth.
+type SupabaseUser struct {
+	// ID is generated if empty.
+	ID       string
+	Email    string
+	Password string
+	// Confirmed marks the email address as confirmed, so the user is able to sign in.
+	Confirmed    bool
+	UserMetadata map[string]any
+	AppMetadata  map[string]any
+}
+
+// createAuthUser inserts the user directly into auth.users. The table is created by the supabase image
+// and extended by the GoTrue migrations, so only columns that exist are filled.
+func createAuthUser(ctx context.Context, dsn string, user SupabaseUser) (string, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT column_name, is_generated = 'ALWAYS' FROM information_schema.columns
+		WHERE table_schema = 'auth' AND table_name = 'users'`)
+	if err != nil {
+		return "", err
+	}
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var generated bool
+		if err := rows.Scan(&name, &generated); err != nil {
+			_ = rows.Close()
+			return "", err
+		}
+		columns[name] = !generated
+	}
+	if err := rows.Close(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("table auth.users does not exist")
+	}
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	appMetadata := map[string]any{"provider": "email", "providers": []string{"email"}}
+	for key, value := range user.AppMetadata {
+		appMetadata[key] = value
+	}
+	rawAppMetadata, err := json.Marshal(appMetadata)
+	if err != nil {
+		return "", err
+	}
+	userMetadata := user.UserMetadata
+	if userMetadata == nil {
+		userMetadata = map[string]any{}
+	}
+	rawUserMetadata, err := json.Marshal(userMetadata)
+	if err != nil {
+		return "", err
+	}
+
+	var names, values []string
+	var args []any
+	set := func(column string, value string, arg ...any) {
+		if !columns[column] {
+			return
+		}
+		names = append(names, pq.QuoteIdentifier(column))
+		for _, a := range arg {
+			args = append(args, a)
+			value = strings.Replace(value, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		values = append(values, value)
+	}
+	set("instance_id", "'00000000-0000-0000-0000-000000000000'")
+	set("id", "?", user.ID)
+	set("aud", "'authenticated'")
+	set("role", "'authenticated'")
+	set("email", "?", user.Email)
+	set("encrypted_password", "extensions.crypt(?, extensions.gen_salt('bf'))", user.Password)
+	set("raw_app_meta_data", "?", string(rawAppMetadata))
+	set("raw_user_meta_data", "?", string(rawUserMetadata))
+	set("created_at", "now()")
+	set("updated_at", "now()")
+	if user.Confirmed {
+		// Newer GoTrue versions derive confirmed_at from email_confirmed_at.
+		if columns["email_confirmed_at"] {
+			set("email_confirmed_at", "now()")
+		} else {
+			set("confirmed_at", "now()")
+		}
+	}
+	// GoTrue is unable to load users with NULL tokens.
+	for _, column := range []string{"confirmation_token", "recovery_token", "email_change_token_new", "email_change"} {
+		set(column, "''")
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO auth.users ("+strings.Join(names, ", ")+
+		") VALUES ("+strings.Join(values, ", ")+")", args...); err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// CreateUser inserts the user directly into auth.users and returns its id.
+func (s *supabasePostgres) CreateUser(ctx context.Context, user SupabaseUser) (string, error) {
+	return createAuthUser(ctx, s.DSN(), user)
+}
+
+func (s *supabase) CreateUser(ctx context.Context, user SupabaseUser) (string, error) {
+	return s.db.CreateUser(ctx, user)
+}
+
+// CreateUserWithGoTrue creates the user through the admin API of GoTrue and returns its id.
+func (s *supabase) CreateUserWithGoTrue(ctx context.Context, user SupabaseUser) (string, error) {
+	body := map[string]any{
+		"email":         user.Email,
+		"password":      user.Password,
+		"email_confirm": user.Confirmed,
+		"user_metadata": user.UserMetadata,
+		"app_metadata":  user.AppMetadata,
+	}
+	if user.ID != "" {
+		body["id"] = user.ID
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.APIURL()+"/auth/v1/admin/users", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", s.keys.ServiceRoleKey)
+	req.Header.Set("Authorization", "Bearer "+s.keys.ServiceRoleKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("creating user failed with %s: %s", resp.Status, respBody)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// MintJWT signs a token for the role with the secret of the stack. Claims override the defaults,
+// which are role, iat and an expiry of one hour.
+func (s *supabase) MintJWT(role string, claims map[string]any) (string, error) {
+	now := time.Now()
+	token := map[string]any{
+		"role": role,
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		token[key] = value
+	}
+	return signJWT(s.keys.JWTSecret, token)
+}
+
+// MintUserJWT signs a token as GoTrue does for the signed-in user, so row-level-security policies
+// using auth.uid() and auth.jwt() see the user.
+func (s *supabase) MintUserJWT(userID string, claims map[string]any) (string, error) {
+	token := map[string]any{
+		"sub": userID,
+		"aud": "authenticated",
+	}
+	for key, value := range claims {
+		token[key] = value
+	}
+	return s.MintJWT("authenticated", token)
+}
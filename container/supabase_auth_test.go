@@ -0,0 +1,36 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupabaseMintJWT(t *testing.T) {
+	s := &supabase{keys: SupabaseKeys{JWTSecret: "secret"}}
+	token, err := s.MintJWT("service_role", map[string]any{"iss": "tests"})
+	require.NoError(t, err)
+	claims := decodeJWT(t, "secret", token)
+	assert.Equal(t, "service_role", claims["role"])
+	assert.Equal(t, "tests", claims["iss"])
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), claims["exp"], 5)
+	assert.NotContains(t, claims, "sub")
+}
+
+func TestSupabaseMintUserJWT(t *testing.T) {
+	s := &supabase{keys: SupabaseKeys{JWTSecret: "secret"}}
+	token, err := s.MintUserJWT("8d0fd2b3-9ca7-4a1a-a0f3-3f4e8ab3e3a1", map[string]any{
+		"email": "user@example.com",
+		"exp":   1700000000,
+	})
+	require.NoError(t, err)
+	claims := decodeJWT(t, "secret", token)
+	assert.Equal(t, "authenticated", claims["role"])
+	assert.Equal(t, "8d0fd2b3-9ca7-4a1a-a0f3-3f4e8ab3e3a1", claims["sub"])
+	assert.Equal(t, "authenticated", claims["aud"])
+	assert.Equal(t, "user@example.com", claims["email"])
+	assert.EqualValues(t, 1700000000, claims["exp"], "claims override the defaults")
+	assert.InDelta(t, time.Now().Unix(), claims["iat"], 5)
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// signJWT signs the claims as HS256 JSON web token.
+func signJWT(secret string, claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// supabaseAPIKey returns a long living key for the anon or service_role role as used by the supabase clients.
+func supabaseAPIKey(secret string, role string) (string, error) {
+	now := time.Now()
+	return signJWT(secret, map[string]any{
+		"iss":  "supabase",
+		"role": role,
+		"iat":  now.Unix(),
+		"exp":  now.AddDate(10, 0, 0).Unix(),
+	})
+}
+
+// randomSecret returns a random hex encoded secret of n bytes.
+func randomSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeJWT verifies the signature of the token with the secret and returns its claims.
+func decodeJWT(t *testing.T, secret string, token string) map[string]any {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2], "signature")
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"alg":"HS256","typ":"JWT"}`, string(header))
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
+
+func TestSupabaseAPIKey(t *testing.T) {
+	secret, err := randomSecret(32)
+	require.NoError(t, err)
+	assert.Len(t, secret, 64)
+
+	key, err := supabaseAPIKey(secret, "anon")
+	require.NoError(t, err)
+	claims := decodeJWT(t, secret, key)
+	assert.Equal(t, "anon", claims["role"])
+	assert.Greater(t, claims["exp"], claims["iat"])
+}
@@ -1,10 +1,14 @@
 package container
 
 import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
 	"github.com/4ND3R50N/testsetup"
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
-	"strconv"
 )
 
 type supabasePostgres struct {
@@ -12,6 +16,25 @@ type supabasePostgres struct {
 	Port     int
 	Opts     testsetup.DockerContainerOpts
 	r        *dockertest.Resource
+	pool     *dockertest.Pool
+	opts     SupabasePostgresContainerOpts
+}
+
+// SupabasePostgresContainer is a Container running the supabase postgres image that provides connection details.
+type SupabasePostgresContainer interface {
+	testsetup.Container
+	// DSN returns a connection string to reach the database as postgres user from the outside.
+	DSN() string
+	// RecordChanges records all row changes committed during a test, see ChangeRecorder.
+	RecordChanges(t testing.TB) *ChangeRecorder
+	// Dump writes a dump of the database, see DumpOpts.
+	Dump(ctx context.Context, w io.Writer, opts DumpOpts) error
+	// Restore restores a dump written by Dump.
+	Restore(ctx context.Context, r io.Reader, opts DumpOpts) error
+	// AssertSchemaGolden compares a schema-only dump with a committed golden file.
+	AssertSchemaGolden(t testing.TB, goldenFile string) bool
+	// CreateUser inserts a user of supabase auth directly into auth.users and returns its id.
+	CreateUser(ctx context.Context, user SupabaseUser) (string, error)
 }
 
 type SupabasePostgresContainerOpts struct {
@@ -28,11 +51,14 @@ type SupabasePostgresContainerOpts struct {
 	DBInternalPort string
 }
 
-func WithSupabasePostgres(opts SupabasePostgresContainerOpts) testsetup.Container {
+// WithSupabasePostgres returns a Container in order to spawn a supabase postgres container.
+// The image is configured with wal_level=logical, so RecordChanges works out of the box.
+func WithSupabasePostgres(opts SupabasePostgresContainerOpts) SupabasePostgresContainer {
 	opts.ExternalDBHost = validateHost(opts.ExternalDBHost)
 	port, _ := strconv.Atoi(opts.ExternalDBHost)
 	return &supabasePostgres{
 		Port: port,
+		opts: opts,
 		Opts: testsetup.DockerContainerOpts{
 			ContainerName: opts.ContainerName,
 			Repository:    "supabase/postgres",
@@ -49,13 +75,12 @@ func WithSupabasePostgres(opts SupabasePostgresContainerOpts) testsetup.Containe
 			},
 			ExpireTime: 5,
 			HealthCheck: func(pool *dockertest.Pool, resource *dockertest.Resource) error {
-				if err := waitForPostgres(pool,
-					opts.ExternalDBHost,
+				if err := waitForPostgres(pool, postgresDSN(opts.ExternalDBHost,
 					opts.DBExternalPort,
 					opts.DBName,
 					"postgres",
 					opts.DBPass,
-					"disable"); err != nil {
+					"disable")); err != nil {
 					return err
 				}
 				return nil
@@ -64,6 +89,21 @@ func WithSupabasePostgres(opts SupabasePostgresContainerOpts) testsetup.Containe
 	}
 }
 
+func (s *supabasePostgres) DSN() string {
+	return postgresDSN(s.opts.ExternalDBHost,
+		s.opts.DBExternalPort,
+		s.opts.DBName,
+		"postgres",
+		s.opts.DBPass,
+		"disable")
+}
+
+// RecordChanges records all row changes committed from now on until the test finishes.
+func (s *supabasePostgres) RecordChanges(t testing.TB) *ChangeRecorder {
+	t.Helper()
+	return newChangeRecorder(t, s.DSN())
+}
+
 func (s *supabasePostgres) GetHostname() string {
 	return s.hostName
 }
@@ -79,6 +119,7 @@ func (s *supabasePostgres) Start(auth docker.AuthConfiguration, pool *dockertest
 	}
 	s.hostName = *hostname
 	s.r = resource
+	s.pool = pool
 	return nil
 }
 
@@ -6,6 +6,9 @@ import (
 	"github.com/ory/dockertest/docker"
 )
 
+// zookeeperClientPort is the port zookeeper accepts clients at within the network.
+const zookeeperClientPort = "2181"
+
 type zookeeper struct {
 	hostName string
 	Opts     testsetup.DockerContainerOpts
@@ -13,6 +16,7 @@ type zookeeper struct {
 }
 
 type ZookeeperOpts struct {
+	// Port is the external client port. Within the network zookeeper is reachable at port 2181.
 	Port          string
 	NetworkID     string
 	ContainerName string
@@ -20,23 +24,29 @@ type ZookeeperOpts struct {
 
 // WithZookeeper returns a container in order to spawn a zookeeper
 func WithZookeeper(opts ZookeeperOpts) testsetup.Container {
-	return &zookeeper{
+	return newZookeeper(opts, nil)
+}
+
+func newZookeeper(opts ZookeeperOpts, env map[string]string) *zookeeper {
+	z := &zookeeper{
 		Opts: testsetup.DockerContainerOpts{
 			Repository:    "confluentinc/cp-zookeeper",
 			ContainerName: opts.ContainerName,
 			Tag:           "7.3.1",
-			PortBinding:   map[string]string{opts.Port: "2181"},
+			PortBinding:   map[string]string{opts.Port: zookeeperClientPort},
 			Env: map[string]string{
-				"ZOOKEEPER_CLIENT_PORT": opts.Port,
+				"ZOOKEEPER_CLIENT_PORT": zookeeperClientPort,
 				"ZOOKEEPER_TICK_TIME":   "2000",
 			},
-			ExpireTime: 5,
-			HealthCheck: func(pool *dockertest.Pool, _ *dockertest.Resource) error {
-				return nil
-			},
-			NetworkID: opts.NetworkID,
+			ExpireTime:  5,
+			HealthCheck: noHealthCheck,
+			NetworkID:   opts.NetworkID,
 		},
 	}
+	for key, value := range env {
+		z.Opts.Env[key] = value
+	}
+	return z
 }
 
 func (z *zookeeper) GetHostname() string {
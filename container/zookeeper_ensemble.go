@@ -0,0 +1,247 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/4ND3R50N/testsetup"
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+)
+
+var (
+	ErrZookeeperNotServing = errors.New("zookeeper is not serving requests")
+	ErrNoZookeeperLeader   = errors.New("zookeeper ensemble has no leader")
+	ErrInvalidPort         = errors.New("invalid port")
+)
+
+type zookeeperEnsemble struct {
+	nodes []*zookeeper
+	opts  ZookeeperEnsembleOpts
+	pool  *dockertest.Pool
+	// invalid is returned on start if the options are unusable, e.g. without nodes.
+	invalid error
+}
+
+// ZookeeperEnsembleContainer is a Container running multiple zookeeper nodes forming a quorum.
+type ZookeeperEnsembleContainer interface {
+	testsetup.Container
+	// ConnectString returns the client addresses of all nodes within the network, e.g. for KafkaOpts.ZookeeperConnect.
+	ConnectString() string
+	// ExternalConnectString returns the client addresses of all nodes reachable from the outside.
+	ExternalConnectString() string
+	// Leader returns the id of the node currently leading the ensemble.
+	Leader(ctx context.Context) (int, error)
+	// KillLeader kills the leading node and returns its id. The remaining nodes elect a new leader
+	// if they still form a quorum, which can be awaited with WaitForQuorum.
+	KillLeader(ctx context.Context) (int, error)
+	// WaitForQuorum waits until every running node serves requests and one of them is the leader.
+	WaitForQuorum() error
+}
+
+type ZookeeperEnsembleOpts struct {
+	// ContainerName is the prefix of the nodes named <name>-1 to <name>-n.
+	ContainerName string
+	NetworkID     string
+	// ExternalHost is the hostname the nodes are reachable at.
+	// For DinD environments this is "docker", for local testing it
+	// is "localhost". If empty "docker" will be set if running in
+	// a CI environment and "localhost" otherwise.
+	ExternalHost string
+	// Port is the external client port of the first node, node i is reachable at Port+i-1.
+	Port string
+}
+
+// WithZookeeperEnsemble returns a Container in order to spawn n zookeeper nodes with the ids 1 to n.
+// The start returns once the nodes elected a leader. An ensemble of n nodes tolerates the loss of (n-1)/2 of them,
+// a single node runs standalone and counts as leader.
+func WithZookeeperEnsemble(n int, opts ZookeeperEnsembleOpts) ZookeeperEnsembleContainer {
+	opts.ExternalHost = validateHost(opts.ExternalHost)
+	basePort, portErr := strconv.Atoi(opts.Port)
+	var servers []string
+	for id := 1; id <= n; id++ {
+		servers = append(servers, opts.ContainerName+"-"+strconv.Itoa(id)+":2888:3888")
+	}
+	e := &zookeeperEnsemble{opts: opts}
+	if n < 1 {
+		e.invalid = fmt.Errorf("%w: a zookeeper ensemble needs at least one node, got %d", ErrInvalidSize, n)
+	} else if portErr != nil {
+		e.invalid = fmt.Errorf("%w: zookeeper ensemble port %q", ErrInvalidPort, opts.Port)
+	}
+	for id := 1; id <= n; id++ {
+		e.nodes = append(e.nodes, newZookeeper(ZookeeperOpts{
+			Port:          strconv.Itoa(basePort + id - 1),
+			NetworkID:     opts.NetworkID,
+			ContainerName: opts.ContainerName + "-" + strconv.Itoa(id),
+		}, map[string]string{
+			// The image writes the id to the myid file.
+			"ZOOKEEPER_SERVER_ID":  strconv.Itoa(id),
+			"ZOOKEEPER_SERVERS":    strings.Join(servers, ";"),
+			"ZOOKEEPER_INIT_LIMIT": "5",
+			"ZOOKEEPER_SYNC_LIMIT": "2",
+		}))
+	}
+	return e
+}
+
+func (e *zookeeperEnsemble) ConnectString() string {
+	addresses := make([]string, 0, len(e.nodes))
+	for _, node := range e.nodes {
+		addresses = append(addresses, node.Opts.ContainerName+":"+zookeeperClientPort)
+	}
+	return strings.Join(addresses, ",")
+}
+
+func (e *zookeeperEnsemble) ExternalConnectString() string {
+	addresses := make([]string, 0, len(e.nodes))
+	for _, node := range e.nodes {
+		for external := range node.Opts.PortBinding {
+			addresses = append(addresses, e.opts.ExternalHost+":"+external)
+		}
+	}
+	return strings.Join(addresses, ",")
+}
+
+func (e *zookeeperEnsemble) Leader(ctx context.Context) (int, error) {
+	errs := []error{ErrNoZookeeperLeader}
+	for i, node := range e.nodes {
+		if node.r == nil {
+			continue
+		}
+		mode, err := zookeeperMode(ctx, e.pool, node.r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %d: %w", i+1, err))
+			continue
+		}
+		if leading(mode) {
+			return i + 1, nil
+		}
+	}
+	return 0, errors.Join(errs...)
+}
+
+func (e *zookeeperEnsemble) KillLeader(ctx context.Context) (int, error) {
+	id, err := e.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	leader := e.nodes[id-1]
+	if err := e.pool.Client.KillContainer(docker.KillContainerOptions{ID: leader.r.Container.ID}); err != nil {
+		return 0, err
+	}
+	// Containers are removed once they exit, so there is nothing left to stop.
+	leader.r = nil
+	return id, nil
+}
+
+func (e *zookeeperEnsemble) WaitForQuorum() error {
+	return e.pool.Retry(func() error {
+		return e.quorum(context.Background())
+	})
+}
+
+// quorum checks that every running node follows or leads and that there is exactly one leader.
+func (e *zookeeperEnsemble) quorum(ctx context.Context) error {
+	leaders := 0
+	for i, node := range e.nodes {
+		if node.r == nil {
+			continue
+		}
+		mode, err := zookeeperMode(ctx, e.pool, node.r)
+		if err != nil {
+			return fmt.Errorf("node %d: %w", i+1, err)
+		}
+		if leading(mode) {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		return fmt.Errorf("%w: %d nodes claim to lead", ErrNoZookeeperLeader, leaders)
+	}
+	return nil
+}
+
+// leading reports whether a node in the mode leads, a standalone node leads its ensemble of one.
+func leading(mode string) bool {
+	return mode == "leader" || mode == "standalone"
+}
+
+// zookeeperMode asks the node for its mode with the srvr command, which is allowed by default.
+func zookeeperMode(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource) (string, error) {
+	var out bytes.Buffer
+	if err := testsetup.ExecInContainer(ctx, pool, resource, testsetup.ExecOpts{
+		Cmd: []string{"bash", "-c",
+			"exec 3<>/dev/tcp/localhost/" + zookeeperClientPort + " && printf srvr >&3 && cat <&3"},
+		Stdout: &out,
+	}); err != nil {
+		return "", err
+	}
+	return parseZookeeperMode(out.String())
+}
+
+// parseZookeeperMode returns "leader", "follower" or "standalone" from the output of the srvr command.
+func parseZookeeperMode(output string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if mode, ok := strings.CutPrefix(scanner.Text(), "Mode: "); ok {
+			return strings.TrimSpace(mode), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrZookeeperNotServing, strings.TrimSpace(output))
+}
+
+func (e *zookeeperEnsemble) GetHostname() string {
+	if len(e.nodes) == 0 {
+		return ""
+	}
+	return e.nodes[0].GetHostname()
+}
+
+func (e *zookeeperEnsemble) GetPorts() []int {
+	var ports []int
+	for _, node := range e.nodes {
+		for external := range node.Opts.PortBinding {
+			port, _ := strconv.Atoi(external)
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func (e *zookeeperEnsemble) Size() int {
+	return len(e.nodes)
+}
+
+func (e *zookeeperEnsemble) Start(auth docker.AuthConfiguration, pool *dockertest.Pool) error {
+	if e.invalid != nil {
+		return e.invalid
+	}
+	e.pool = pool
+	for _, node := range e.nodes {
+		if err := node.Start(auth, pool); err != nil {
+			return err
+		}
+	}
+	return e.WaitForQuorum()
+}
+
+func (e *zookeeperEnsemble) Stop() error {
+	var errs []error
+	for _, node := range e.nodes {
+		if node.r != nil {
+			errs = append(errs, node.Stop())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *zookeeperEnsemble) SetLabel(label map[string]string) {
+	for _, node := range e.nodes {
+		node.SetLabel(label)
+	}
+}
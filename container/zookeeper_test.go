@@ -0,0 +1,80 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithZookeeperClientPort(t *testing.T) {
+	z := WithZookeeper(ZookeeperOpts{ContainerName: "zk", Port: "2182"}).(*zookeeper)
+	assert.Equal(t, "2181", z.Opts.Env["ZOOKEEPER_CLIENT_PORT"])
+	assert.Equal(t, map[string]string{"2182": "2181"}, z.Opts.PortBinding)
+}
+
+func TestWithZookeeperEnsemble(t *testing.T) {
+	e := WithZookeeperEnsemble(3, ZookeeperEnsembleOpts{
+		ContainerName: "zk",
+		ExternalHost:  "localhost",
+		Port:          "2191",
+	}).(*zookeeperEnsemble)
+	require.Equal(t, 3, e.Size())
+	assert.Equal(t, "zk-1:2181,zk-2:2181,zk-3:2181", e.ConnectString())
+	assert.Equal(t, "localhost:2191,localhost:2192,localhost:2193", e.ExternalConnectString())
+	assert.Equal(t, []int{2191, 2192, 2193}, e.GetPorts())
+
+	node := e.nodes[1]
+	assert.Equal(t, "zk-2", node.Opts.ContainerName)
+	assert.Equal(t, "2", node.Opts.Env["ZOOKEEPER_SERVER_ID"])
+	assert.Equal(t, "zk-1:2888:3888;zk-2:2888:3888;zk-3:2888:3888", node.Opts.Env["ZOOKEEPER_SERVERS"])
+	assert.Equal(t, "2181", node.Opts.Env["ZOOKEEPER_CLIENT_PORT"])
+}
+
+func TestWithZookeeperEnsembleSize(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		e := WithZookeeperEnsemble(n, ZookeeperEnsembleOpts{ContainerName: "zk", Port: "2191"})
+		assert.Empty(t, e.GetHostname())
+		assert.ErrorIs(t, e.Start(docker.AuthConfiguration{}, nil), ErrInvalidSize)
+	}
+	for _, port := range []string{"", "zk"} {
+		e := WithZookeeperEnsemble(3, ZookeeperEnsembleOpts{ContainerName: "zk", Port: port})
+		assert.ErrorIs(t, e.Start(docker.AuthConfiguration{}, nil), ErrInvalidPort)
+	}
+}
+
+func TestZookeeperLeading(t *testing.T) {
+	assert.True(t, leading("leader"))
+	assert.True(t, leading("standalone"), "a single node leads its ensemble")
+	assert.False(t, leading("follower"))
+	assert.False(t, leading("observer"))
+}
+
+func TestParseZookeeperMode(t *testing.T) {
+	mode, err := parseZookeeperMode("Zookeeper version: 3.6.3\nLatency min/avg/max: 0/0.0/0\n" +
+		"Received: 1\nSent: 0\nConnections: 1\nOutstanding: 0\nZxid: 0x100000000\nMode: leader\nNode count: 5\n")
+	require.NoError(t, err)
+	assert.Equal(t, "leader", mode)
+
+	mode, err = parseZookeeperMode("Mode: follower\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "follower", mode)
+
+	_, err = parseZookeeperMode("This ZooKeeper instance is not currently serving requests\n")
+	assert.ErrorIs(t, err, ErrZookeeperNotServing)
+	assert.ErrorContains(t, err, "not currently serving requests")
+}
+
+func TestKafkaZookeeperConnect(t *testing.T) {
+	k := WithKafka(KafkaOpts{
+		ContainerName:     "kafka",
+		ZookeeperHostName: "zk",
+		ZookeeperPort:     "2181",
+		ZookeeperConnect:  "zk-1:2181,zk-2:2181,zk-3:2181",
+	}).(*kafka)
+	assert.Equal(t, "zk-1:2181,zk-2:2181,zk-3:2181", k.Opts.Env["KAFKA_ZOOKEEPER_CONNECT"])
+
+	k = WithKafka(KafkaOpts{ContainerName: "kafka", ZookeeperHostName: "zk", ZookeeperPort: "2181"}).(*kafka)
+	assert.Equal(t, "zk:2181", k.Opts.Env["KAFKA_ZOOKEEPER_CONNECT"])
+}
@@ -1,7 +1,10 @@
 package testsetup
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -31,11 +34,22 @@ type DockerContainerOpts struct {
 	Env         map[string]string // key: env var name, Value: value
 	Commands    []string          // Don´t set this option if there are no commands to perform!
 	EntryPoint  []string          // Don´t set this option if there are no entry points to change!
+	Mounts      map[string]string // key: path on the docker host, Value: path inside the container
 	Labels      map[string]string
 	ExpireTime  time.Duration
 	HealthCheck func(pool *dockertest.Pool, resource *dockertest.Resource) error
 }
 
+type ExecOpts struct {
+	Cmd  []string
+	Env  map[string]string // key: env var name, Value: value
+	User string            // Defaults to the user of the container.
+	// Stdin is streamed to the command if set.
+	Stdin io.Reader
+	// Stdout receives the output of the command if set.
+	Stdout io.Writer
+}
+
 // CreateNetwork creates a docker network used so container can communicate with each other
 func CreateNetwork(pool *dockertest.Pool, name string) (*docker.Network, error) {
 	nw, err := pool.Client.CreateNetwork(docker.CreateNetworkOptions{Name: name})
@@ -71,6 +85,11 @@ func RunDockerContainer(auth docker.AuthConfiguration, pool *dockertest.Pool, op
 		envList = append(envList, key+"="+value)
 	}
 
+	var mounts []string
+	for source, target := range opts.Mounts {
+		mounts = append(mounts, source+":"+target)
+	}
+
 	portBindings := make(map[docker.Port][]docker.PortBinding, len(opts.PortBinding))
 	for portToReach, portToExpose := range opts.PortBinding {
 		portBindings[docker.Port(portToExpose+"/tcp")] = []docker.PortBinding{
@@ -89,6 +108,7 @@ func RunDockerContainer(auth docker.AuthConfiguration, pool *dockertest.Pool, op
 		Env:          envList,
 		Cmd:          opts.Commands,
 		Entrypoint:   opts.EntryPoint,
+		Mounts:       mounts,
 		PortBindings: portBindings,
 		NetworkID:    opts.NetworkID,
 		Labels:       opts.Labels,
@@ -114,3 +134,49 @@ func RunDockerContainer(auth docker.AuthConfiguration, pool *dockertest.Pool, op
 
 	return resource, &domainName, nil
 }
+
+// ExecInContainer runs a command inside a running container and waits for it to exit.
+// A non-zero exit code is returned as error together with the output on stderr.
+func ExecInContainer(ctx context.Context, pool *dockertest.Pool, resource *dockertest.Resource, opts ExecOpts) error {
+	var envList []string
+	for key, value := range opts.Env {
+		envList = append(envList, key+"="+value)
+	}
+	exec, err := pool.Client.CreateExec(docker.CreateExecOptions{
+		Context:      ctx,
+		Container:    resource.Container.ID,
+		Cmd:          opts.Cmd,
+		Env:          envList,
+		User:         opts.User,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var stderr bytes.Buffer
+	if err := pool.Client.StartExec(exec.ID, docker.StartExecOptions{
+		Context:      ctx,
+		InputStream:  opts.Stdin,
+		OutputStream: stdout,
+		ErrorStream:  &stderr,
+	}); err != nil {
+		return err
+	}
+
+	inspect, err := pool.Client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d: %s",
+			strings.Join(opts.Cmd, " "), inspect.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
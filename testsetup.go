@@ -23,6 +23,12 @@ type Container interface {
 	Stop() error
 }
 
+// Group is implemented by containers that run more than one docker container, e.g. a database with replicas.
+type Group interface {
+	// Size returns the number of docker containers that are started and labeled.
+	Size() int
+}
+
 type TestSetup struct {
 	testSetupID string
 	aborted     error
@@ -111,7 +117,7 @@ func (t *TestSetup) WaitUntilStarted() error {
 		c, _ := t.pool.Client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{
 			"label": {t.testSetupID},
 		}})
-		if len(c) == len(t.services) {
+		if len(c) == t.expectedContainers() {
 			return nil
 		}
 		return ErrNotReady
@@ -121,3 +127,15 @@ func (t *TestSetup) WaitUntilStarted() error {
 	}
 	return nil
 }
+
+func (t *TestSetup) expectedContainers() int {
+	count := 0
+	for _, service := range t.services {
+		if group, ok := service.(Group); ok {
+			count += group.Size()
+			continue
+		}
+		count++
+	}
+	return count
+}
@@ -1,8 +1,16 @@
 package testsetup_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"github.com/segmentio/kafka-go"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -55,7 +63,7 @@ func TestTestSetup_Start(t *testing.T) {
 		ExternalHostName:  container.AutoGuessHostname(),
 		ExternalPort:      "9092",
 		ZookeeperHostName: zookeeperContainerName,
-		ZookeeperPort:     zookeeper.Port,
+		ZookeeperPort:     "2181",
 		NetworkID:         networkID,
 	}
 	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{},
@@ -83,7 +91,7 @@ func TestTestSetup_Start(t *testing.T) {
 	kafkaContainerOpts.ContainerNamePort = "9094"
 	kafkaContainerOpts.ExternalPort = "9093"
 	kafkaContainerOpts.ZookeeperHostName = zookeeper.ContainerName
-	kafkaContainerOpts.ZookeeperPort = zookeeper.Port
+	kafkaContainerOpts.ZookeeperPort = "2181"
 
 	testSetup2 := testsetup.NewTestSetup(docker.AuthConfiguration{},
 		networkID2,
@@ -189,3 +197,905 @@ func TestTestSetup_TestPanicRecovery(t *testing.T) {
 	_, err = pool.Client.NetworkInfo(networkID)
 	require.Error(t, err)
 }
+
+func TestTestSetup_PostgresTLS(t *testing.T) {
+	networkID := "TestTestSetup_PostgresTLS-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "test",
+		DBUser:         "test",
+		DBPass:         "test",
+		DBExternalPort: "5434",
+		DBInternalPort: "5432",
+		TLS:            &container.PostgresTLSOpts{RequireClientCert: true},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	require.NotNil(t, postgres.Certificates())
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	var ssl bool
+	require.NoError(t, db.QueryRow("SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl))
+	assert.True(t, ssl)
+}
+
+func TestTestSetup_PostgresReplicated(t *testing.T) {
+	networkID := "TestTestSetup_PostgresReplicated-" + uuid.New().String()
+	postgres := container.WithPostgresReplicated(container.PostgresReplicatedOpts{
+		Primary: container.PostgresContainerOpts{
+			ContainerName:  "postgres-" + uuid.New().String(),
+			NetworkID:      networkID,
+			DBName:         "test",
+			DBUser:         "test",
+			DBPass:         "test",
+			DBExternalPort: "5435",
+			DBInternalPort: "5432",
+		},
+		ReplicaExternalPorts: []string{"5436", "5437"},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	primary, err := sql.Open("postgres", postgres.WriteDSN())
+	require.NoError(t, err)
+	defer primary.Close()
+	_, err = primary.Exec("CREATE TABLE items (id int)")
+	require.NoError(t, err)
+	_, err = primary.Exec("INSERT INTO items VALUES (1)")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	require.NoError(t, postgres.WaitForReplication(ctx))
+	require.Len(t, postgres.ReadDSNs(), 2)
+	for _, dsn := range postgres.ReadDSNs() {
+		replica, err := sql.Open("postgres", dsn)
+		require.NoError(t, err)
+		var count int
+		require.NoError(t, replica.QueryRow("SELECT count(*) FROM items").Scan(&count))
+		assert.Equal(t, 1, count)
+		_, err = replica.Exec("INSERT INTO items VALUES (2)")
+		assert.Error(t, err, "replicas are read only")
+		require.NoError(t, replica.Close())
+	}
+}
+
+func TestTestSetup_PostgresIsolatedSchema(t *testing.T) {
+	networkID := "TestTestSetup_PostgresIsolatedSchema-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "test",
+		DBUser:         "test",
+		DBPass:         "test",
+		DBExternalPort: "5438",
+		DBInternalPort: "5432",
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	var first, second *container.Schema
+	t.Run("first", func(t *testing.T) {
+		first = postgres.IsolatedSchema(t, "CREATE TABLE items (id int)", "INSERT INTO items VALUES (1)")
+		second = postgres.IsolatedSchema(t, "CREATE TABLE items (id int)")
+		var count int
+		require.NoError(t, first.DB.QueryRow("SELECT count(*) FROM items").Scan(&count))
+		assert.Equal(t, 1, count)
+		require.NoError(t, second.DB.QueryRow("SELECT count(*) FROM items").Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	var schemas int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM information_schema.schemata WHERE schema_name IN ($1, $2)",
+		first.Name, second.Name).Scan(&schemas))
+	assert.Equal(t, 0, schemas, "schemas are dropped after the test")
+}
+
+func TestTestSetup_KafkaKRaft(t *testing.T) {
+	networkID := "TestTestSetup_KafkaKRaft-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9095",
+		NetworkID:         networkID,
+		KRaft:             true,
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	conn, err := kafka.Dial("tcp", container.AutoGuessHostname()+":9095")
+	require.NoError(t, err)
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions("your.topic.1")
+	require.NoError(t, err)
+	assert.Len(t, partitions, 1)
+}
+
+func TestTestSetup_KafkaSCRAMWithTLS(t *testing.T) {
+	networkID := "TestTestSetup_KafkaSCRAMWithTLS-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9096",
+		NetworkID:         networkID,
+		KRaft:             true,
+		Security: &container.KafkaSecurityOpts{
+			SASL:  container.KafkaSASLScramSHA512,
+			TLS:   true,
+			Users: map[string]string{"service": "service-secret"},
+		},
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	dialer, err := kafkaContainer.Dialer("service")
+	require.NoError(t, err)
+	conn, err := dialer.Dial("tcp", kafkaContainer.Brokers()[0])
+	require.NoError(t, err)
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions("your.topic.1")
+	require.NoError(t, err)
+	assert.Len(t, partitions, 1)
+
+	_, err = kafka.Dial("tcp", kafkaContainer.Brokers()[0])
+	assert.Error(t, err)
+}
+
+func TestTestSetup_KafkaACLs(t *testing.T) {
+	networkID := "TestTestSetup_KafkaACLs-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9097",
+		NetworkID:         networkID,
+		KRaft:             true,
+		Security: &container.KafkaSecurityOpts{
+			SASL:       container.KafkaSASLPlain,
+			Users:      map[string]string{"admin": "admin-secret", "service": "service-secret", "other": "other-secret"},
+			ACLs:       true,
+			SuperUsers: []string{"admin"},
+		},
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	transport, err := kafkaContainer.Transport("service")
+	require.NoError(t, err)
+	writer := &kafka.Writer{
+		Addr:        kafka.TCP(kafkaContainer.Brokers()...),
+		Topic:       "your.topic.1",
+		Transport:   transport,
+		MaxAttempts: 1,
+	}
+	defer writer.Close()
+	ctx := context.Background()
+	assert.Error(t, writer.WriteMessages(ctx, kafka.Message{Value: []byte("denied")}))
+
+	acl := container.TopicACL("service", "your.topic.1", kafka.ACLOperationTypeWrite, kafka.ACLOperationTypeDescribe)
+	require.NoError(t, kafkaContainer.GrantACL(ctx, acl))
+	assert.NoError(t, writer.WriteMessages(ctx, kafka.Message{Value: []byte("allowed")}))
+
+	otherTransport, err := kafkaContainer.Transport("other")
+	require.NoError(t, err)
+	other := &kafka.Writer{
+		Addr:        kafka.TCP(kafkaContainer.Brokers()...),
+		Topic:       "your.topic.1",
+		Transport:   otherTransport,
+		MaxAttempts: 1,
+	}
+	defer other.Close()
+	assert.Error(t, other.WriteMessages(ctx, kafka.Message{Value: []byte("not granted")}),
+		"users without ACLs stay denied")
+
+	require.NoError(t, kafkaContainer.RevokeACL(ctx, acl))
+	assert.Error(t, writer.WriteMessages(ctx, kafka.Message{Value: []byte("denied again")}))
+}
+
+func TestTestSetup_SchemaRegistry(t *testing.T) {
+	networkID := "TestTestSetup_SchemaRegistry-" + uuid.New().String()
+	schemaFile := filepath.Join(t.TempDir(), "order.avsc")
+	require.NoError(t, os.WriteFile(schemaFile,
+		[]byte(`{"type": "record", "name": "Order", "fields": [{"name": "id", "type": "string"}]}`), 0600))
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9098",
+		NetworkID:         networkID,
+		KRaft:             true,
+	})
+	registry := container.WithSchemaRegistry(container.SchemaRegistryOpts{
+		ContainerName:          "schema-registry-" + uuid.New().String(),
+		NetworkID:              networkID,
+		KafkaContainerNamePort: "9091",
+		ExternalPort:           "8085",
+		Compatibility:          container.SchemaCompatibilityFull,
+		Schemas:                []container.RegistrySchema{{Subject: "your.topic.1-value", File: schemaFile}},
+	}, kafkaContainer)
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer, registry)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	id := registry.SchemaIDs()["your.topic.1-value"]
+	assert.NotZero(t, id)
+
+	incompatibleFile := filepath.Join(t.TempDir(), "order.avsc")
+	require.NoError(t, os.WriteFile(incompatibleFile,
+		[]byte(`{"type": "record", "name": "Order", "fields": [{"name": "id", "type": "int"}]}`), 0600))
+	_, err := registry.RegisterSchema(context.Background(),
+		container.RegistrySchema{Subject: "your.topic.1-value", File: incompatibleFile})
+	assert.Error(t, err)
+}
+
+func TestTestSetup_KafkaConnect(t *testing.T) {
+	networkID := "TestTestSetup_KafkaConnect-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9099",
+		NetworkID:         networkID,
+		KRaft:             true,
+	})
+	connect := container.WithKafkaConnect(container.KafkaConnectOpts{
+		ContainerName:          "connect-" + uuid.New().String(),
+		NetworkID:              networkID,
+		KafkaContainerNamePort: "9091",
+		ExternalPort:           "8083",
+	}, kafkaContainer)
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer, connect)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	ctx := context.Background()
+	require.NoError(t, connect.CreateConnector(ctx, container.KafkaConnector{
+		Name: "heartbeats",
+		Config: map[string]string{
+			"connector.class":                     "org.apache.kafka.connect.mirror.MirrorHeartbeatConnector",
+			"source.cluster.alias":                "source",
+			"target.cluster.alias":                "target",
+			"target.cluster.bootstrap.servers":    kafkaContainer.GetHostname() + ":9091",
+			"heartbeats.topic.replication.factor": "1",
+			"tasks.max":                           "1",
+		},
+	}))
+	status, err := connect.ConnectorStatus(ctx, "heartbeats")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", status.Connector.State)
+	require.NoError(t, connect.DeleteConnector(ctx, "heartbeats"))
+}
+
+func TestTestSetup_DebeziumPostgres(t *testing.T) {
+	networkID := "TestTestSetup_DebeziumPostgres-" + uuid.New().String()
+	cdc := container.WithDebeziumPostgres(container.DebeziumPostgresOpts{
+		ContainerName:       "cdc-" + uuid.New().String(),
+		NetworkID:           networkID,
+		DBName:              "shop",
+		DBUser:              "user",
+		DBPass:              "pass",
+		DBExternalPort:      "5439",
+		KafkaExternalPort:   "9100",
+		ConnectExternalPort: "8084",
+		Setup:               []string{"CREATE TABLE public.orders (id int PRIMARY KEY, item text)"},
+		Tables:              []string{"public.orders"},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, cdc)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	db, err := sql.Open("postgres", cdc.Postgres().DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO public.orders VALUES (1, 'book')")
+	require.NoError(t, err)
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cdc.Kafka().Brokers(),
+		Topic:   cdc.Topic("public.orders"),
+	})
+	defer reader.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	msg, err := reader.ReadMessage(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(msg.Value), `"op":"c"`)
+	assert.Contains(t, string(msg.Value), `"item":"book"`)
+}
+
+func TestTestSetup_KafkaExpectMessages(t *testing.T) {
+	networkID := "TestTestSetup_KafkaExpectMessages-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9101",
+		NetworkID:         networkID,
+		KRaft:             true,
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	transport, err := kafkaContainer.Transport("")
+	require.NoError(t, err)
+	writer := &kafka.Writer{Addr: kafka.TCP(kafkaContainer.Brokers()...), Topic: "your.topic.1", Transport: transport}
+	defer writer.Close()
+	require.NoError(t, writer.WriteMessages(context.Background(),
+		kafka.Message{Key: []byte("1"), Value: []byte(`{"status": "created"}`)},
+		kafka.Message{Key: []byte("1"), Value: []byte(`{"status": "paid"}`),
+			Headers: []kafka.Header{{Key: "type", Value: []byte("payment")}}},
+	))
+
+	messages := kafkaContainer.ExpectMessagesWith(t, "your.topic.1", container.ExpectOpts{InOrder: true, Exactly: true},
+		container.Message(container.Key("1"), container.ValuePath("$.status", "created")),
+		container.Message(container.Header("type", "payment"), container.ValuePath("$.status", "paid")),
+	)
+	assert.Len(t, messages, 2)
+}
+
+func TestTestSetup_KafkaExpectTransactionalMessages(t *testing.T) {
+	networkID := "TestTestSetup_KafkaExpectTransactionalMessages-" + uuid.New().String()
+	kafkaContainerName := "kafka-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     kafkaContainerName,
+		ContainerNamePort: "9091",
+		ExternalPort:      "9106",
+		NetworkID:         networkID,
+		KRaft:             true,
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	recorder := kafkaContainer.RecordTopics(t, container.TopicRecorderOpts{Topics: "your.topic.1"})
+	produceTransactional(t, kafkaContainerName, kafkaContainerName+":9091", "your.topic.1", 3)
+	assert.Len(t, recorder.Records(), 3)
+
+	// The partition ends with the commit marker, one offset past the last message.
+	messages := kafkaContainer.ExpectMessagesWith(t, "your.topic.1", container.ExpectOpts{Exactly: true},
+		container.Message(container.ValuePath("$.status", "committed")),
+		container.Message(container.ValuePath("$.status", "committed")),
+		container.Message(container.ValuePath("$.status", "committed")),
+	)
+	assert.Len(t, messages, 3)
+}
+
+// produceTransactional writes the records with a transactional producer from within the kafka container.
+func produceTransactional(t *testing.T, containerName string, bootstrap string, topic string, records int) {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	require.NoError(t, err)
+	kafkaContainer, err := pool.Client.InspectContainer(containerName)
+	require.NoError(t, err)
+	require.NoError(t, testsetup.ExecInContainer(context.Background(), pool,
+		&dockertest.Resource{Container: kafkaContainer}, testsetup.ExecOpts{
+			Cmd: []string{"bash", "-c", `echo '{"status": "committed"}' > /tmp/payload && ` +
+				"kafka-producer-perf-test --topic " + topic + " --num-records " + fmt.Sprint(records) +
+				" --throughput -1 --payload-file /tmp/payload --producer-props bootstrap.servers=" + bootstrap +
+				" --transactional-id " + topic + "-producer --transaction-duration-ms 100"},
+		}))
+}
+
+func TestTestSetup_KafkaTopicRecorder(t *testing.T) {
+	networkID := "TestTestSetup_KafkaTopicRecorder-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9102",
+		NetworkID:         networkID,
+		KRaft:             true,
+	}, "your.topic.1", "other.topic")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	transport, err := kafkaContainer.Transport("")
+	require.NoError(t, err)
+	writer := &kafka.Writer{Addr: kafka.TCP(kafkaContainer.Brokers()...), Transport: transport}
+	defer writer.Close()
+	ctx := context.Background()
+	require.NoError(t, writer.WriteMessages(ctx, kafka.Message{Topic: "your.topic.1", Value: []byte("before")}))
+
+	recorder := kafkaContainer.RecordTopics(t, container.TopicRecorderOpts{Topics: `^your\.`})
+	require.NoError(t, writer.WriteMessages(ctx,
+		kafka.Message{Topic: "your.topic.1", Key: []byte("1"), Value: []byte("during"),
+			Headers: []kafka.Header{{Key: "type", Value: []byte("order")}}},
+		kafka.Message{Topic: "other.topic", Value: []byte("ignored")},
+	))
+
+	records := recorder.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "your.topic.1", records[0].Topic)
+	assert.Equal(t, container.RecordBytes("during"), records[0].Value)
+	assert.Equal(t, []container.RecordHeader{{Key: "type", Value: container.RecordBytes("order")}}, records[0].Headers)
+}
+
+func TestTestSetup_KafkaFixtures(t *testing.T) {
+	networkID := "TestTestSetup_KafkaFixtures-" + uuid.New().String()
+	fixture := filepath.Join(t.TempDir(), "orders.jsonl")
+	require.NoError(t, os.WriteFile(fixture, []byte(`{"key": "1", "value": "{\"status\": \"created\"}"}
+{"key": "1", "headers": [{"key": "type", "value": "payment"}], "value": "{\"status\": \"paid\"}"}
+`), 0600))
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9103",
+		NetworkID:         networkID,
+		KRaft:             true,
+		Topics:            []container.TopicSpec{{Name: "your.topic.1", Partitions: 3, Fixtures: []string{fixture}}},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	kafkaContainer.ExpectMessagesWith(t, "your.topic.1", container.ExpectOpts{InOrder: true, Exactly: true},
+		container.Message(container.Key("1"), container.ValuePath("$.status", "created")),
+		container.Message(container.Header("type", "payment"), container.ValuePath("$.status", "paid")),
+	)
+
+	captured := filepath.Join(t.TempDir(), "orders.bin")
+	n, err := kafkaContainer.CaptureFixture(context.Background(), "your.topic.1", captured)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestTestSetup_KafkaConsumerGroups(t *testing.T) {
+	networkID := "TestTestSetup_KafkaConsumerGroups-" + uuid.New().String()
+	kafkaContainer := container.WithKafka(container.KafkaOpts{
+		ContainerName:     "kafka-" + uuid.New().String(),
+		ContainerNamePort: "9091",
+		ExternalPort:      "9104",
+		NetworkID:         networkID,
+		KRaft:             true,
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, kafkaContainer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	transport, err := kafkaContainer.Transport("")
+	require.NoError(t, err)
+	writer := &kafka.Writer{Addr: kafka.TCP(kafkaContainer.Brokers()...), Topic: "your.topic.1", Transport: transport}
+	defer writer.Close()
+	require.NoError(t, writer.WriteMessages(ctx,
+		kafka.Message{Value: []byte("1")}, kafka.Message{Value: []byte("2")}, kafka.Message{Value: []byte("3")}))
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: kafkaContainer.Brokers(),
+		GroupID: "your.group",
+		Topic:   "your.topic.1",
+	})
+	for i := 0; i < 3; i++ {
+		_, err := reader.ReadMessage(ctx)
+		require.NoError(t, err)
+	}
+	require.NoError(t, kafkaContainer.WaitForGroupCaughtUp(ctx, "your.group", "your.topic.1"))
+	assert.ErrorIs(t, kafkaContainer.ResetGroupOffsets(ctx, "your.group", "your.topic.1", kafka.FirstOffset),
+		container.ErrGroupNotEmpty)
+	require.NoError(t, reader.Close())
+
+	require.NoError(t, kafkaContainer.ResetGroupOffsets(ctx, "your.group", "your.topic.1", kafka.FirstOffset))
+	groups, err := kafkaContainer.DescribeConsumerGroups(ctx, "your.group")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, int64(3), groups[0].Lag())
+
+	require.NoError(t, kafkaContainer.DeleteGroupOffsets(ctx, "your.group"))
+	groups, err = kafkaContainer.DescribeConsumerGroups(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestTestSetup_Redpanda(t *testing.T) {
+	networkID := "TestTestSetup_Redpanda-" + uuid.New().String()
+	schemaFile := filepath.Join(t.TempDir(), "order.avsc")
+	require.NoError(t, os.WriteFile(schemaFile,
+		[]byte(`{"type": "record", "name": "Order", "fields": [{"name": "id", "type": "string"}]}`), 0600))
+	redpanda := container.WithRedpanda(container.RedpandaOpts{
+		ContainerName:              "redpanda-" + uuid.New().String(),
+		ContainerNamePort:          "9091",
+		ExternalPort:               "9105",
+		SchemaRegistryExternalPort: "8086",
+		NetworkID:                  networkID,
+		Schemas:                    []container.RegistrySchema{{Subject: "your.topic.1-value", File: schemaFile}},
+	}, "your.topic.1")
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, redpanda)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	assert.Contains(t, redpanda.SchemaIDs(), "your.topic.1-value")
+	transport, err := redpanda.Transport("")
+	require.NoError(t, err)
+	writer := &kafka.Writer{Addr: kafka.TCP(redpanda.Brokers()...), Topic: "your.topic.1", Transport: transport}
+	defer writer.Close()
+	require.NoError(t, writer.WriteMessages(context.Background(), kafka.Message{Value: []byte(`{"id": "1"}`)}))
+	redpanda.ExpectMessages(t, "your.topic.1", container.Message(container.ValuePath("$.id", "1")))
+}
+
+func TestTestSetup_ZookeeperEnsemble(t *testing.T) {
+	networkID := "TestTestSetup_ZookeeperEnsemble-" + uuid.New().String()
+	ensemble := container.WithZookeeperEnsemble(3, container.ZookeeperEnsembleOpts{
+		ContainerName: "zookeeper-" + uuid.New().String(),
+		Port:          "2191",
+		NetworkID:     networkID,
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, ensemble)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	ctx := context.Background()
+	killed, err := ensemble.KillLeader(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ensemble.WaitForQuorum())
+	leader, err := ensemble.Leader(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, killed, leader)
+}
+
+func TestTestSetup_PostgresExtensions(t *testing.T) {
+	networkID := "TestTestSetup_PostgresExtensions-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "test",
+		DBUser:         "test",
+		DBPass:         "test",
+		DBExternalPort: "5440",
+		DBInternalPort: "5432",
+		Image:          container.PostgresImagePgVector,
+		Extensions:     []container.PostgresExtension{{Name: "vector", Version: "0.7.4"}},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	assert.Equal(t, map[string]string{"vector": "0.7.4"}, postgres.Extensions())
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	var distance float64
+	require.NoError(t, db.QueryRow("SELECT '[1,2]'::vector <-> '[4,6]'::vector").Scan(&distance))
+	assert.Equal(t, 5.0, distance)
+}
+
+func TestTestSetup_PostgresCaptureQueries(t *testing.T) {
+	networkID := "TestTestSetup_PostgresCaptureQueries-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "test",
+		DBUser:         "test",
+		DBPass:         "test",
+		DBExternalPort: "5441",
+		DBInternalPort: "5432",
+		StatementLog:   &container.PostgresStatementLogOpts{},
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE items (id int)")
+	require.NoError(t, err)
+
+	capture := postgres.CaptureQueries(t)
+	var id int
+	assert.ErrorIs(t, db.QueryRow("SELECT id FROM items WHERE id = $1", 42).Scan(&id), sql.ErrNoRows)
+	_, err = db.Exec("SELECT * FROM missing")
+	require.Error(t, err)
+
+	queries := capture.Queries()
+	require.Len(t, queries, 2, capture.Transcript())
+	assert.Equal(t, "SELECT id FROM items WHERE id = $1", queries[0].Statement)
+	assert.Equal(t, "$1 = '42'", queries[0].Parameters)
+	assert.Empty(t, queries[0].Error)
+	assert.Equal(t, "SELECT * FROM missing", queries[1].Statement)
+	assert.Contains(t, queries[1].Error, `relation "missing" does not exist`)
+}
+
+func TestTestSetup_PostgresRecordChanges(t *testing.T) {
+	networkID := "TestTestSetup_PostgresRecordChanges-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:   "postgres-" + uuid.New().String(),
+		NetworkID:       networkID,
+		DBName:          "test",
+		DBUser:          "test",
+		DBPass:          "test",
+		DBExternalPort:  "5442",
+		DBInternalPort:  "5432",
+		LogicalDecoding: true,
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE items (id int PRIMARY KEY, name text)")
+	require.NoError(t, err)
+
+	t.Run("record", func(t *testing.T) {
+		recorder := postgres.RecordChanges(t)
+		_, err := db.Exec("INSERT INTO items VALUES (1, 'first')")
+		require.NoError(t, err)
+		_, err = db.Exec("UPDATE items SET name = NULL WHERE id = 1")
+		require.NoError(t, err)
+		_, err = db.Exec("DELETE FROM items WHERE id = 1")
+		require.NoError(t, err)
+
+		assert.Equal(t, []container.Change{
+			{Operation: "INSERT", Schema: "public", Table: "items", New: map[string]any{"id": "1", "name": "first"}},
+			{Operation: "UPDATE", Schema: "public", Table: "items", New: map[string]any{"id": "1", "name": nil}},
+			{Operation: "DELETE", Schema: "public", Table: "items", Old: map[string]any{"id": "1", "name": nil}},
+		}, recorder.Changes())
+	})
+
+	var slots int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM pg_replication_slots").Scan(&slots))
+	assert.Equal(t, 0, slots, "the replication slot is dropped after the test")
+}
+
+func TestTestSetup_PgBouncerTransactionPooling(t *testing.T) {
+	networkID := "TestTestSetup_PgBouncerTransactionPooling-" + uuid.New().String()
+	postgresOpts := container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "test",
+		DBUser:         "test",
+		DBPass:         "test",
+		DBExternalPort: "5443",
+		DBInternalPort: "5432",
+	}
+	postgres := container.WithPostgres(postgresOpts)
+	bouncer := container.WithPgBouncer(container.PgBouncerOpts{
+		ContainerName: "pgbouncer-" + uuid.New().String(),
+		NetworkID:     networkID,
+		PoolMode:      container.PgBouncerTransactionPooling,
+		DBName:        postgresOpts.DBName,
+		DBUser:        postgresOpts.DBUser,
+		DBPass:        postgresOpts.DBPass,
+		ExternalPort:  "6432",
+	}, postgres)
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres, bouncer)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	db, err := sql.Open("postgres", bouncer.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+	stmt, err := db.Prepare("SELECT $1::int * 2")
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	// Every transaction may run on another server connection than the one the statement was prepared on.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx, err := db.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+			var doubled int
+			if err := tx.Stmt(stmt).QueryRow(i).Scan(&doubled); err != nil {
+				errs <- err
+				return
+			}
+			if doubled != 2*i {
+				errs <- fmt.Errorf("got %d for %d", doubled, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestTestSetup_PostgresDumpRestore(t *testing.T) {
+	networkID := "TestTestSetup_PostgresDumpRestore-" + uuid.New().String()
+	postgres := container.WithPostgres(container.PostgresContainerOpts{
+		ContainerName:  "postgres-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "postgres",
+		DBUser:         "postgres",
+		DBPass:         "test",
+		DBExternalPort: "5444",
+		DBInternalPort: "5432",
+	})
+	supabase := container.WithSupabasePostgres(container.SupabasePostgresContainerOpts{
+		ContainerName:  "supabase-" + uuid.New().String(),
+		NetworkID:      networkID,
+		DBName:         "postgres",
+		DBPass:         "test",
+		DBExternalPort: "54324",
+		DBInternalPort: "5432",
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, postgres, supabase)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	ctx := context.Background()
+	db, err := sql.Open("postgres", postgres.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE items (id int PRIMARY KEY, name text)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO items VALUES (1, 'first'), (2, 'second')")
+	require.NoError(t, err)
+
+	var dump bytes.Buffer
+	require.NoError(t, postgres.Dump(ctx, &dump, container.DumpOpts{}))
+	_, err = db.Exec("DROP TABLE items")
+	require.NoError(t, err)
+	require.NoError(t, postgres.Restore(ctx, bytes.NewReader(dump.Bytes()), container.DumpOpts{}))
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM items").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// The dump of plain postgres only holds the items table, which fits into the supabase database.
+	require.NoError(t, supabase.Restore(ctx, bytes.NewReader(dump.Bytes()), container.DumpOpts{}))
+	var schema bytes.Buffer
+	require.NoError(t, supabase.Dump(ctx, &schema, container.DumpOpts{Format: container.DumpFormatPlain, SchemaOnly: true}))
+	assert.Contains(t, schema.String(), "CREATE TABLE public.items")
+	supabaseDB, err := sql.Open("postgres", supabase.DSN())
+	require.NoError(t, err)
+	defer supabaseDB.Close()
+	require.NoError(t, supabaseDB.QueryRow("SELECT count(*) FROM items").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestTestSetup_Supabase(t *testing.T) {
+	networkID := "TestTestSetup_Supabase-" + uuid.New().String()
+	supabase := container.WithSupabase(container.SupabaseOpts{
+		ContainerName:   "supabase-" + uuid.New().String(),
+		NetworkID:       networkID,
+		DBPass:          "test",
+		DBExternalPort:  "54325",
+		APIExternalPort: "8000",
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, supabase)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	get := func(path string, apiKey string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, supabase.APIURL()+path, nil)
+		require.NoError(t, err)
+		if apiKey != "" {
+			req.Header.Set("apikey", apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	health := get("/auth/v1/health", supabase.Keys().AnonKey)
+	require.Equal(t, http.StatusOK, health.StatusCode)
+	var gotrue struct {
+		Name string `json:"name"`
+	}
+	require.NoError(t, json.NewDecoder(health.Body).Decode(&gotrue))
+	assert.Equal(t, "GoTrue", gotrue.Name)
+
+	rest := get("/rest/v1/", supabase.Keys().AnonKey)
+	require.Equal(t, http.StatusOK, rest.StatusCode)
+	var openAPI map[string]any
+	require.NoError(t, json.NewDecoder(rest.Body).Decode(&openAPI))
+	assert.Contains(t, openAPI, "paths")
+
+	assert.Equal(t, http.StatusUnauthorized, get("/rest/v1/", "").StatusCode, "kong requires an api key")
+}
+
+func TestTestSetup_SupabaseUsers(t *testing.T) {
+	networkID := "TestTestSetup_SupabaseUsers-" + uuid.New().String()
+	supabase := container.WithSupabase(container.SupabaseOpts{
+		ContainerName:   "supabase-" + uuid.New().String(),
+		NetworkID:       networkID,
+		DBPass:          "test",
+		DBExternalPort:  "54326",
+		APIExternalPort: "8001",
+	})
+	testSetup := testsetup.NewTestSetup(docker.AuthConfiguration{}, networkID, supabase)
+	testSetup.Start()
+	require.NoError(t, testSetup.WaitUntilStarted())
+	defer testSetup.Stop()
+
+	ctx := context.Background()
+	sqlUser, err := supabase.CreateUser(ctx, container.SupabaseUser{
+		Email: "sql@example.com", Password: "secret-sql", Confirmed: true,
+	})
+	require.NoError(t, err)
+	goTrueUser, err := supabase.CreateUserWithGoTrue(ctx, container.SupabaseUser{
+		Email: "gotrue@example.com", Password: "secret-gotrue", Confirmed: true,
+	})
+	require.NoError(t, err)
+
+	request := func(method string, path string, token string, body string) *http.Response {
+		req, err := http.NewRequest(method, supabase.APIURL()+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("apikey", supabase.Keys().AnonKey)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+	for email, password := range map[string]string{"sql@example.com": "secret-sql", "gotrue@example.com": "secret-gotrue"} {
+		resp := request(http.MethodPost, "/auth/v1/token?grant_type=password", "",
+			fmt.Sprintf(`{"email": %q, "password": %q}`, email, password))
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "sign in of %s", email)
+	}
+
+	db, err := sql.Open("postgres", supabase.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE notes (owner uuid NOT NULL, body text NOT NULL)",
+		"ALTER TABLE notes ENABLE ROW LEVEL SECURITY",
+		"CREATE POLICY own_notes ON notes FOR SELECT TO authenticated USING (owner = auth.uid())",
+		"GRANT SELECT ON notes TO authenticated",
+		"NOTIFY pgrst, 'reload schema'",
+	} {
+		_, err := db.Exec(statement)
+		require.NoError(t, err, statement)
+	}
+	_, err = db.Exec("INSERT INTO notes VALUES ($1, 'from sql'), ($2, 'from gotrue')", sqlUser, goTrueUser)
+	require.NoError(t, err)
+
+	token, err := supabase.MintUserJWT(goTrueUser, nil)
+	require.NoError(t, err)
+	var notes []struct {
+		Body string `json:"body"`
+	}
+	require.Eventually(t, func() bool {
+		resp := request(http.MethodGet, "/rest/v1/notes?select=body", token, "")
+		return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&notes) == nil
+	}, 10*time.Second, 250*time.Millisecond, "PostgREST did not pick up the notes table")
+	require.Len(t, notes, 1)
+	assert.Equal(t, "from gotrue", notes[0].Body)
+}